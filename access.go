@@ -0,0 +1,246 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+)
+
+//==============================================================================================================================
+//	 Access control
+//==============================================================================================================================
+//	Callers are identified by attributes of their enrollment certificate:
+//		userId - the user name on the certificate in the CA
+//		role   - one of the Role* constants below
+//		party  - the legal party the user acts for, as named in the Requester, Approver, Owner and Issuer columns
+//==============================================================================================================================
+
+const (
+	attrUserId = "userId"
+	attrRole   = "role"
+	attrParty  = "party"
+)
+
+const (
+	RoleApplicant   = "applicant"
+	RoleBankOfficer = "bank_officer"
+	RoleBeneficiary = "beneficiary"
+	RoleAuditor     = "auditor"
+	RoleAdmin       = "admin"
+)
+
+// Caller is the identity of the client submitting the transaction
+type Caller struct {
+	UserId string
+	Role   string
+	Party  string
+}
+
+// IsParty returns true if the caller acts for one of the given parties
+func (c Caller) IsParty(parties ...string) bool {
+	for _, p := range parties {
+		if c.Party != "" && c.Party == p {
+			return true
+		}
+	}
+	return false
+}
+
+// HasRole returns true if the caller holds one of the given roles
+func (c Caller) HasRole(roles ...string) bool {
+	for _, r := range roles {
+		if c.Role == r {
+			return true
+		}
+	}
+	return false
+}
+
+// get_caller reads the identity of the caller from its certificate attributes
+func get_caller(stub Stub) (Caller, error) {
+	var c Caller
+
+	userId, err := stub.ReadCertAttribute(attrUserId)
+	if err != nil {
+		return c, forbidden(ErrorDetails{"attribute": attrUserId}, "Could not read attribute %s from caller certificate", attrUserId)
+	}
+	role, err := stub.ReadCertAttribute(attrRole)
+	if err != nil {
+		return c, forbidden(ErrorDetails{"attribute": attrRole}, "Could not read attribute %s from caller certificate", attrRole)
+	}
+	// party is optional for admins and auditors
+	party, _ := stub.ReadCertAttribute(attrParty)
+
+	c.UserId = string(userId)
+	c.Role = string(role)
+	c.Party = string(party)
+	return c, nil
+}
+
+// require_role returns the caller if its certificate carries one of the given roles
+func require_role(stub Stub, roles ...string) (Caller, error) {
+	c, err := get_caller(stub)
+	if err != nil {
+		return c, err
+	}
+	for _, r := range roles {
+		ok, err := stub.VerifyAttribute(attrRole, []byte(r))
+		if err == nil && ok {
+			return c, nil
+		}
+	}
+	return c, forbidden(ErrorDetails{"role": c.Role, "roles": roles}, "Role %s is not allowed to call this function", c.Role)
+}
+
+// check_active_user returns an error if the caller is a registered user that has been deactivated, or that
+// acts for another party than the organisation it is registered under.
+// Callers without a user record are only known by their certificate and pass.
+func check_active_user(stub Stub) error {
+	c, err := get_caller(stub)
+	if err != nil {
+		return err
+	}
+	u, err := read_user(stub, c.UserId)
+	if err != nil {
+		if has_code(err, CodeNotFound) {
+			return nil
+		}
+		return err
+	}
+	if u.Status == UserStatusDeactivated {
+		return forbidden(ErrorDetails{"userId": c.UserId, "status": u.Status}, "User %s is deactivated", c.UserId)
+	}
+	if u.Organisation != "" && u.Organisation != c.Party {
+		return forbidden(ErrorDetails{"userId": c.UserId, "organisation": u.Organisation, "party": c.Party}, "User %s is registered under %s and can not act for %s", c.UserId, u.Organisation, c.Party)
+	}
+	return nil
+}
+
+// check_party returns an error unless the caller acts for one of the given parties.
+// Auditors and admins are not bound to a party; the dispatcher has already checked their role.
+func check_party(stub Stub, parties ...string) error {
+	c, err := get_caller(stub)
+	if err != nil {
+		return err
+	}
+	if c.HasRole(RoleAuditor, RoleAdmin) {
+		return nil
+	}
+	if !c.IsParty(parties...) {
+		return forbidden(ErrorDetails{"party": c.Party, "parties": parties}, "Caller does not act for a party of this record")
+	}
+	return nil
+}
+
+//==============================================================================================================================
+//	 Permissions - The Permissions column of requests and documents is an access-control list granting rights
+//				   to parties and roles:
+//		{"parties": {"BankA": ["read", "cancel"], "CorpB": ["read", "amend"]}, "roles": {"auditor": ["read"]}}
+//	A record whose list grants nothing, as well as rows written before the list was enforced, falls back to the
+//	parties of the record. Auditors and admins can always read. Approve, amend, cancel and claim stay with the
+//	parties of the record, a list can only narrow them down.
+//==============================================================================================================================
+
+const (
+	RightRead    = "read"
+	RightAmend   = "amend"
+	RightCancel  = "cancel"
+	RightClaim   = "claim"
+	RightApprove = "approve"
+)
+
+var rights = []string{RightRead, RightAmend, RightCancel, RightClaim, RightApprove}
+
+// Permissions is the access-control list of a request or document
+type Permissions struct {
+	Parties map[string][]string `json:"parties,omitempty"`
+	Roles   map[string][]string `json:"roles,omitempty"`
+}
+
+// parsePermissions reads an access-control list, rejecting unknown fields and rights
+func parsePermissions(value []byte) (Permissions, error) {
+	var p Permissions
+	dec := json.NewDecoder(bytes.NewReader(value))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&p); err != nil {
+		return p, invalidArgument(ErrorDetails{"field": "Permissions"}, "Invalid Permissions: %s", err.Error())
+	}
+	for _, grants := range []map[string][]string{p.Parties, p.Roles} {
+		for name, granted := range grants {
+			for _, right := range granted {
+				if !contains(rights, right) {
+					return p, invalidArgument(ErrorDetails{"field": "Permissions", "right": right}, "Invalid Permissions: unknown right %s for %s. Expecting one of %s.", right, name, strings.Join(rights, ", "))
+				}
+			}
+		}
+	}
+	return p, nil
+}
+
+// checkPermissions validates the Permissions of a record before it is written. An empty value is
+// accepted: the record then has no list, and an amendment keeps the list of the document.
+func checkPermissions(value []byte) error {
+	if len(value) == 0 {
+		return nil
+	}
+	if err := checkJSON("Permissions", value); err != nil {
+		return err
+	}
+	_, err := parsePermissions(value)
+	return err
+}
+
+// isEmpty returns true if the list grants nothing to anyone
+func (p Permissions) isEmpty() bool {
+	return len(p.Parties) == 0 && len(p.Roles) == 0
+}
+
+// allows returns true if the list grants right to the caller's party or role
+func (p Permissions) allows(c Caller, right string) bool {
+	if c.Party != "" && contains(p.Parties[c.Party], right) {
+		return true
+	}
+	return contains(p.Roles[c.Role], right)
+}
+
+// mentions returns true if the list grants right to any party or role
+func (p Permissions) mentions(right string) bool {
+	for _, grants := range []map[string][]string{p.Parties, p.Roles} {
+		for _, granted := range grants {
+			if contains(granted, right) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// check_permission returns a forbidden error unless the caller holds right on a record with the
+// given Permissions. Without a usable list, the caller must act for one of the parties.
+// A list can grant read to anyone. Every other right changes the record and the list is written by the
+// applicant, so the caller must act for one of the parties whatever the list says. A list granting the
+// right to some parties or roles then restricts it to them, a list that does not mention it changes nothing.
+func check_permission(stub Stub, permissions []byte, right string, parties ...string) error {
+	p, err := parsePermissions(permissions)
+	usable := err == nil && !p.isEmpty()
+	if right != RightRead || !usable {
+		if err := check_party(stub, parties...); err != nil {
+			return err
+		}
+		if !usable || (right != RightRead && !p.mentions(right)) {
+			return nil
+		}
+	}
+
+	c, err := get_caller(stub)
+	if err != nil {
+		return err
+	}
+	if right == RightRead && c.HasRole(RoleAuditor, RoleAdmin) {
+		return nil
+	}
+	if !p.allows(c, right) {
+		return forbidden(ErrorDetails{"right": right}, "Caller does not hold the %s right on this record", right)
+	}
+	return nil
+}
@@ -0,0 +1,229 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+)
+
+//==============================================================================================================================
+//	 Audit trail - Records are overwritten in place when their status changes, so every write made by an invoke
+//				   also appends an entry to the AuditTable. Entries are never replaced or deleted. They are keyed by
+//				   the kind and UID of the record they are about, then by a sequence number within that record.
+//==============================================================================================================================
+
+// Kinds of records the audit trail is kept for. Claim UIDs are only unique within their document,
+// their trail is kept under "<documentUid>/<claimUid>".
+const (
+	AuditKindRequest      = "request"
+	AuditKindDocument     = "document"
+	AuditKindClaim        = "claim"
+	AuditKindUser         = "user"
+	AuditKindDocumentType = "document_type"
+	AuditKindIndex        = "index"
+	AuditKindOrganisation = "organisation"
+)
+
+// AuditEntry is one change to a record
+type AuditEntry struct {
+	Kind      string   `json:"kind"`
+	TargetUid string   `json:"targetUid"`
+	Seq       string   `json:"seq"`
+	TxId      string   `json:"txId"`
+	UserId    string   `json:"userId"`
+	Role      string   `json:"role"`
+	Party     string   `json:"party"`
+	Function  string   `json:"function"`
+	TargetKey []string `json:"targetKey"` //Key columns of the record in its table
+	OldStatus string   `json:"oldStatus"`
+	NewStatus string   `json:"newStatus"`
+	Timestamp string   `json:"timestamp"`
+}
+
+func auditFromRow(row Row) AuditEntry {
+	e := AuditEntry{
+		Kind:      row.Columns[0].GetString_(),
+		TargetUid: row.Columns[1].GetString_(),
+		Seq:       row.Columns[2].GetString_(),
+		TxId:      row.Columns[3].GetString_(),
+		UserId:    row.Columns[4].GetString_(),
+		Role:      row.Columns[5].GetString_(),
+		Party:     row.Columns[6].GetString_(),
+		Function:  row.Columns[7].GetString_(),
+		OldStatus: row.Columns[9].GetString_(),
+		NewStatus: row.Columns[10].GetString_(),
+		Timestamp: row.Columns[11].GetString_(),
+	}
+	json.Unmarshal(row.Columns[8].GetBytes(), &e.TargetKey)
+	return e
+}
+
+// create_audit_table creates the audit table if it does not exist yet
+func create_audit_table(stub Stub) error {
+	_, err := stub.GetTable("AuditTable")
+	if err == nil {
+		// Table already exists; do not recreate
+		return nil
+	}
+
+	err = stub.CreateTable("AuditTable", []*ColumnDefinition{
+		&ColumnDefinition{Name: "Kind", Type: ColumnDefinition_STRING, Key: true},
+		&ColumnDefinition{Name: "TargetUid", Type: ColumnDefinition_STRING, Key: true},
+		&ColumnDefinition{Name: "Seq", Type: ColumnDefinition_STRING, Key: true},
+		&ColumnDefinition{Name: "TxId", Type: ColumnDefinition_STRING, Key: false},
+		&ColumnDefinition{Name: "UserId", Type: ColumnDefinition_STRING, Key: false},
+		&ColumnDefinition{Name: "Role", Type: ColumnDefinition_STRING, Key: false},
+		&ColumnDefinition{Name: "Party", Type: ColumnDefinition_STRING, Key: false},
+		&ColumnDefinition{Name: "Function", Type: ColumnDefinition_STRING, Key: false},
+		&ColumnDefinition{Name: "TargetKey", Type: ColumnDefinition_BYTES, Key: false},
+		&ColumnDefinition{Name: "OldStatus", Type: ColumnDefinition_STRING, Key: false},
+		&ColumnDefinition{Name: "NewStatus", Type: ColumnDefinition_STRING, Key: false},
+		&ColumnDefinition{Name: "Timestamp", Type: ColumnDefinition_STRING, Key: false},
+	})
+	if err != nil {
+		return errors.New("Failed creating AuditTable.")
+	}
+	return nil
+}
+
+// audit appends an entry for a change of the record kind/uid from oldStatus to newStatus.
+// An empty oldStatus means the record was created.
+func audit(stub Stub, kind string, uid string, key []string, oldStatus string, newStatus string) error {
+
+	caller, err := get_caller(stub)
+	if err != nil {
+		return err
+	}
+	now, err := timestamp(stub)
+	if err != nil {
+		return err
+	}
+	keyAsBytes, err := json.Marshal(key)
+	if err != nil {
+		return errors.New("Error marshalling audit key")
+	}
+
+	// Entries of a record are numbered in the order they were written. The transaction does not read
+	// its own entries back, the ones it wrote before are counted on the invocation.
+	rows, err := stub.GetRows("AuditTable", keyColumns(kind, uid))
+	if err != nil {
+		return fmt.Errorf("Failed to retrieve rows")
+	}
+	inv := stub.Invocation()
+	if inv.Audited == nil {
+		inv.Audited = map[string]int{}
+	}
+	seq := inv.Audited[kind+"/"+uid]
+	for row := range rows {
+		if len(row.Columns) != 0 {
+			seq++
+		}
+	}
+	inv.Audited[kind+"/"+uid]++
+
+	ok, err := stub.InsertRow("AuditTable", Row{
+		Columns: []*Column{
+			&Column{Value: &Column_String_{String_: kind}},
+			&Column{Value: &Column_String_{String_: uid}},
+			&Column{Value: &Column_String_{String_: fmt.Sprintf("%010d", seq)}},
+			&Column{Value: &Column_String_{String_: stub.TxID()}},
+			&Column{Value: &Column_String_{String_: caller.UserId}},
+			&Column{Value: &Column_String_{String_: caller.Role}},
+			&Column{Value: &Column_String_{String_: caller.Party}},
+			&Column{Value: &Column_String_{String_: stub.Invocation().Function}},
+			&Column{Value: &Column_Bytes{Bytes: keyAsBytes}},
+			&Column{Value: &Column_String_{String_: oldStatus}},
+			&Column{Value: &Column_String_{String_: newStatus}},
+			&Column{Value: &Column_String_{String_: now}}},
+	})
+	if !ok && err == nil {
+		return errors.New("Error writing the audit trail of " + kind + " " + uid + ".")
+	}
+	return err
+}
+
+// GetAuditTrail () – returns the changes made to a request, document or other record, oldest first.
+// The parties of a request or document can read its trail, other kinds are for auditors and admins.
+func (t *SimpleChaincode) GetAuditTrail(stub Stub, args []string) ([]byte, error) {
+
+	//Args
+	//		0		1		2
+	//		kind	uid		list options (optional)
+	if len(args) != 2 && len(args) != 3 {
+		return nil, argCount("2 or 3")
+	}
+	kind := args[0]
+	uid := args[1]
+	o, err := listOptionsArg(args, 2)
+	if err != nil {
+		return nil, err
+	}
+	if o.DocumentType != "" {
+		return nil, invalidArgument(ErrorDetails{"field": "options"}, "Invalid list options: the audit trail can not be filtered by documentType")
+	}
+
+	caller, err := get_caller(stub)
+	if err != nil {
+		return nil, err
+	}
+	if !caller.HasRole(RoleAuditor, RoleAdmin) {
+		switch kind {
+		case AuditKindDocument:
+			d, err := t.document.getByUid(stub, uid)
+			if err != nil {
+				return nil, err
+			}
+			if err := check_party(stub, d.Owner, d.Issuer); err != nil {
+				return nil, err
+			}
+		case AuditKindRequest:
+			key, found, err := get_uid_index(stub, requestUidIndex, uid)
+			if err != nil {
+				return nil, err
+			}
+			if !found {
+				return nil, notFound(ErrorDetails{"kind": AuditKindRequest, "uid": uid}, "Request with uid %s does not exist.", uid)
+			}
+			// Request keys are RequestType, Requester, Approver
+			if err := check_party(stub, key[1], key[2]); err != nil {
+				return nil, err
+			}
+		default:
+			return nil, forbidden(ErrorDetails{"kind": kind}, "Only auditors and admins can read the audit trail of a %s", kind)
+		}
+	}
+
+	rows, err := stub.GetRows("AuditTable", keyColumns(kind, uid))
+	if err != nil {
+		return nil, fmt.Errorf("Failed to retrieve rows")
+	}
+
+	entries := []AuditEntry{}
+	for row := range rows {
+		if len(row.Columns) == 0 {
+			continue
+		}
+		e := auditFromRow(row)
+		if o.match(e.NewStatus, e.Timestamp, "") {
+			entries = append(entries, e)
+		}
+	}
+
+	// Seq is zero padded, so sorting the strings sorts the entries in the order they were written
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].Seq < entries[j].Seq
+	})
+
+	keys := make([]listKey, len(entries))
+	for i, e := range entries {
+		keys[i] = listKey{e.Seq}
+	}
+	first, last, next, err := paginate(keys, o, false)
+	if err != nil {
+		return nil, err
+	}
+
+	page := entries[first:last]
+	return json.Marshal(ListResponse{Count: len(page), Data: page, NextCursor: next})
+}
@@ -1,15 +1,21 @@
 package main
 
 import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/binary"
+	"encoding/hex"
 	"encoding/json"
-	"errors"
 	"fmt"
-	"github.com/hyperledger/fabric/core/chaincode/shim"
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+	pb "github.com/hyperledger/fabric-protos-go/peer"
 	"strconv"
+	"strings"
 	"os"
 )
 
-var logger = shim.NewLogger("lg-project")
+var logger = NewLogger("lg-project")
 //==============================================================================================================================
 //	 Structure Definitions
 //==============================================================================================================================
@@ -19,97 +25,119 @@ var logger = shim.NewLogger("lg-project")
 type SimpleChaincode struct {
 	request Request
 	document Document
+	claim Claim
+	documentTypes DocumentTypes
+	organisations Organisations
 }
 
 type ECertResponse struct {
 	OK string `json:"OK"`
 }
 
+// ListResponse is returned by every query listing records. NextCursor is empty on the last page.
+type ListResponse struct {
+	Count      int         `json:"count"`
+	Data       interface{} `json:"data"`
+	NextCursor string      `json:"nextCursor"`
+}
+
+// CreatedResponse is returned by the invokes creating a record, with the uid of the record.
+// A client leaving the uid empty learns the uid new_id allocated from it.
+type CreatedResponse struct {
+	Uid string `json:"uid"`
+}
+
+// AuthenticateResponse is returned by authenticate. User is only set when authenticated.
+type AuthenticateResponse struct {
+	Authenticated bool  `json:"authenticated"`
+	User          *User `json:"user,omitempty"`
+}
+
 type User struct {
 	UserId       string   `json:"userId"` //Same username as on certificate in CA
-	Salt         string   `json:"salt"`
-	Hash         string   `json:"hash"`
+	Salt         string   `json:"salt,omitempty"` //Hex encoded, at least saltMinBytes long
+	Hash         string   `json:"hash,omitempty"` //Hex encoded PBKDF2-HMAC-SHA256 of the password and Salt
 	FirstName    string   `json:"firstName"`
 	LastName     string   `json:"lastName"`
 	Things       []string `json:"things"` //Array of thing IDs
 	Address      string   `json:"address"`
 	PhoneNumber  string   `json:"phoneNumber"`
 	EmailAddress string   `json:"emailAddress"`
+	Organisation string   `json:"organisation"` //Id of the registered organisation the user acts for
+	Role         string   `json:"role"` //One of the Role* constants, as on the certificate
+	Status       string   `json:"status"` //UserStatusActive or UserStatusDeactivated
 }
 
+// UserProfile holds the fields of a user its owner can change through update_user. Missing fields are left unchanged.
+type UserProfile struct {
+	FirstName    *string `json:"firstName"`
+	LastName     *string `json:"lastName"`
+	Address      *string `json:"address"`
+	PhoneNumber  *string `json:"phoneNumber"`
+	EmailAddress *string `json:"emailAddress"`
+}
+
+// User statuses. Users registered before statuses existed are active.
+const (
+	UserStatusActive      = "active"
+	UserStatusDeactivated = "deactivated"
+)
+
 
 //=================================================================================================================================
-//  Index collections - In order to create new IDs dynamically and in progressive sorting
-//  Example:
-//    signaturesAsBytes, err := stub.GetState(signaturesIndexStr)
-//    if err != nil { return nil, errors.New("Failed to get Signatures Index") }
-//    fmt.Println("Signature index retrieved")
-//
-//    // Unmarshal the signatures index
-//    var signaturesIndex []string
-//    json.Unmarshal(signaturesAsBytes, &signaturesIndex)
-//    fmt.Println("Signature index unmarshalled")
-//
-//    // Create new id for the signature
-//    var newSignatureId string
-//    newSignatureId = "sg" + strconv.Itoa(len(signaturesIndex) + 1)
-//
-//    // append the new signature to the index
-//    signaturesIndex = append(signaturesIndex, newSignatureId)
-//    jsonAsBytes, _ := json.Marshal(signaturesIndex)
-//    err = stub.PutState(signaturesIndexStr, jsonAsBytes)
-//    if err != nil { return nil, errors.New("Error storing new signaturesIndex into ledger") }
+//  Entity indexes - Every index lists the IDs of one kind of entity under its own composite keys (ids.go)
 //=================================================================================================================================
 var usersIndexStr = "_users"
 
+// Password hashing parameters. Clients must derive User.Hash with the same values
+// when registering a user through add_user.
+const (
+	passwordIterations = 10000
+	passwordKeyBytes   = sha256.Size
+	saltMinBytes       = 16
+)
+
+// Transient data keys of change_password
+const (
+	transientCurrentPassword = "currentPassword"
+	transientNewPassword     = "newPassword"
+)
+
 var indexes = []string{usersIndexStr}
 
 //==============================================================================================================================
-//	Invoke - Called on chaincode invoke. Takes a function name passed and calls that function. Passes the
-//  		 initial arguments passed are passed on to the called function.
+//	Invoke - Called on chaincode invoke. Looks the function name up in the function registry (dispatch.go)
+//  		 and calls that function with the arguments passed, once the registry has checked them.
 //==============================================================================================================================
 
-func (t *SimpleChaincode) Invoke(stub *shim.ChaincodeStub, function string, args []string) ([]byte, error) {
-	logger.Infof("Invoke is running " + function)
+func (t *SimpleChaincode) Invoke(stub shim.ChaincodeStubInterface) pb.Response {
+	function, args := stub.GetFunctionAndParameters()
 
-	if function == "init" {
-		return t.Init(stub, "init", args)
-	} else if function == "reset_indexes" {
-		return t.reset_indexes(stub, args)
-	} else if function == "add_user" {
-		return t.add_user(stub, args)
-	} else if function == "submit_new_request" {
-		return t.request.SubmitNewRequest(stub,args)
-	} else if function == "approve_new_request" {
-		return t.request.ApproveRequest(stub,args)
-	} else if function == "issue_document" {
-		return t.document.IssueDocument(stub,args)
-	}else if function == "cancel_lg_document" {
-		return t.document.CancelLGDocument(stub,args)
+	// Fabric 1.x and later have no query entry point: clients evaluate query functions through Invoke
+	// without submitting the transaction for ordering
+	if _, found := lookup_function(KindQuery, function); found {
+		return response(t.query(newPeerStub(stub), function, args))
 	}
+	return response(t.invoke(newPeerStub(stub), function, args))
+}
+
+func (t *SimpleChaincode) invoke(stub Stub, function string, args []string) ([]byte, error) {
+	logger.Infof("Invoke is running " + function)
+
+	// The audit trail records which function made each change
+	stub.Invocation().Function = function
 
-	return nil, errors.New("Received unknown invoke function name")
+	return t.dispatch(stub, KindInvoke, function, args)
 }
 
 //=================================================================================================================================
-//	Query - Called on chaincode query. Takes a function name passed and calls that function. Passes the
-//  		initial arguments passed are passed on to the called function.
+//	Query - Called through Invoke for the query functions. Looks the function name up in the function registry
+//  		(dispatch.go) and calls that function with the arguments passed, once the registry has checked them.
 //=================================================================================================================================
-func (t *SimpleChaincode) Query(stub *shim.ChaincodeStub, function string, args []string) ([]byte, error) {
+func (t *SimpleChaincode) query(stub Stub, function string, args []string) ([]byte, error) {
 	logger.Infof("Query is running " + function)
 
-	if function == "get_user" {
-		return t.get_user(stub, args[1])
-	} else if function == "authenticate" {
-		return t.authenticate(stub, args)
-	} else if function == "get_request_json" {
-		return t.request.GetJSON(stub,args)
-	}else if function == "get_lg_document_json" {
-		return t.document.GetLgJSON(stub,args)
-	}else if function == "get_new_requests" {
-		return t.request.GetNewRequests(stub,args)
-	}
-	return nil, errors.New("Received unknown query function name")
+	return t.dispatch(stub, KindQuery, function, args)
 }
 
 //=================================================================================================================================
@@ -118,11 +146,8 @@ func (t *SimpleChaincode) Query(stub *shim.ChaincodeStub, function string, args
 
 func main() {
 
-	// LogDebug, LogInfo, LogNotice, LogWarning, LogError, LogCritical (Default: LogDebug)
-	logger.SetLevel(shim.LogDebug)
-
-	logLevel, _ := shim.LogLevel(os.Getenv("SHIM_LOGGING_LEVEL"))
-	shim.SetLoggingLevel(logLevel)
+	// DEBUG, INFO, WARNING (Default: DEBUG)
+	logger.SetLevel(os.Getenv("SHIM_LOGGING_LEVEL"))
 
 	err := shim.Start(new(SimpleChaincode))
 	if err != nil {
@@ -134,11 +159,32 @@ func main() {
 //  Init Function - Called when the user deploys the chaincode
 //==============================================================================================================================
 
-func (t *SimpleChaincode) Init(stub *shim.ChaincodeStub, function string, args []string) ([]byte, error) {
+func (t *SimpleChaincode) Init(stub shim.ChaincodeStubInterface) pb.Response {
+	_, args := stub.GetFunctionAndParameters()
+	return response(t.init(newPeerStub(stub), "init", args))
+}
+
+func (t *SimpleChaincode) init(stub Stub, function string, args []string) ([]byte, error) {
 
-	t.request.Init(stub, function, args)
+	if err := create_audit_table(stub); err != nil {
+		return nil, err
+	}
 
-		t.document.Init(stub, function, args)
+	if _, err := t.request.Init(stub, function, args); err != nil {
+		return nil, err
+	}
+	if _, err := t.document.Init(stub, function, args); err != nil {
+		return nil, err
+	}
+	if _, err := t.claim.Init(stub, function, args); err != nil {
+		return nil, err
+	}
+	if _, err := t.documentTypes.Init(stub, function, args); err != nil {
+		return nil, err
+	}
+	if _, err := t.organisations.Init(stub, function, args); err != nil {
+		return nil, err
+	}
 	return nil, nil
 }
 
@@ -146,125 +192,514 @@ func (t *SimpleChaincode) Init(stub *shim.ChaincodeStub, function string, args [
 //  Utility Functions
 //==============================================================================================================================
 
-// "create":  true -> create new ID, false -> append the id
-func append_id(stub *shim.ChaincodeStub, indexStr string, id string, create bool) ([]byte, error) {
-
-	indexAsBytes, err := stub.GetState(indexStr)
+// response converts the result of a function to the response of the peer. Errors are returned as
+// the JSON of a ChaincodeError.
+func response(payload []byte, err error) pb.Response {
 	if err != nil {
-		return nil, errors.New("Failed to get " + indexStr)
+		return shim.Error(as_chaincode_error(err).Error())
 	}
+	return shim.Success(payload)
+}
 
-	// Unmarshal the index
-	var tmpIndex []string
-	json.Unmarshal(indexAsBytes, &tmpIndex)
+// keyColumns returns the key columns of a table row. Passing fewer values than the table has key
+// columns selects every row sharing that prefix of the key.
+func keyColumns(values ...string) []Column {
+	var columns []Column
+	for _, v := range values {
+		columns = append(columns, Column{Value: &Column_String_{String_: v}})
+	}
+	return columns
+}
 
-	// Create new id
-	var newId = id
-	if create {
-		newId += strconv.Itoa(len(tmpIndex) + 1)
+// checkJSON returns an error unless value is well formed JSON
+func checkJSON(name string, value []byte) error {
+	if !json.Valid(value) {
+		return invalidArgument(ErrorDetails{"field": name}, "Invalid %s: expecting a JSON value", name)
 	}
+	return nil
+}
 
-	// append the new id to the index
-	tmpIndex = append(tmpIndex, newId)
+// rawJSON embeds a stored JSON column in a response. Rows written before the input was validated
+// may hold anything, so malformed values are embedded as a JSON string instead.
+func rawJSON(value []byte) json.RawMessage {
+	if len(value) == 0 {
+		return json.RawMessage("null")
+	}
+	if json.Valid(value) {
+		return json.RawMessage(value)
+	}
+	quoted, _ := json.Marshal(string(value))
+	return json.RawMessage(quoted)
+}
 
-	jsonAsBytes, _ := json.Marshal(tmpIndex)
-	err = stub.PutState(indexStr, jsonAsBytes)
-	if err != nil {
-		return nil, errors.New("Error storing new " + indexStr + " into ledger")
+// pbkdf2 derives a key from password and salt as specified in RFC 2898, using HMAC-SHA256
+// as the pseudorandom function.
+func pbkdf2(password, salt []byte, iterations, keyLen int) []byte {
+	prf := hmac.New(sha256.New, password)
+	hashLen := prf.Size()
+	numBlocks := (keyLen + hashLen - 1) / hashLen
+
+	var buf [4]byte
+	dk := make([]byte, 0, numBlocks*hashLen)
+	u := make([]byte, hashLen)
+	for block := 1; block <= numBlocks; block++ {
+		prf.Reset()
+		prf.Write(salt)
+		binary.BigEndian.PutUint32(buf[:], uint32(block))
+		prf.Write(buf[:])
+		dk = prf.Sum(dk)
+		t := dk[len(dk)-hashLen:]
+		copy(u, t)
+
+		for n := 2; n <= iterations; n++ {
+			prf.Reset()
+			prf.Write(u)
+			u = u[:0]
+			u = prf.Sum(u)
+			for x := range u {
+				t[x] ^= u[x]
+			}
+		}
 	}
+	return dk[:keyLen]
+}
 
-	return []byte(newId), nil
+// validate_credentials checks that the Salt and Hash of a user are present and well formed
+func validate_credentials(u User) error {
+	salt, err := hex.DecodeString(u.Salt)
+	if err != nil || len(salt) < saltMinBytes {
+		return invalidArgument(ErrorDetails{"field": "salt"}, "Invalid salt for user %s: expecting at least %d hex encoded bytes", u.UserId, saltMinBytes)
+	}
+	hash, err := hex.DecodeString(u.Hash)
+	if err != nil || len(hash) != passwordKeyBytes {
+		return invalidArgument(ErrorDetails{"field": "hash"}, "Invalid hash for user %s: expecting %d hex encoded bytes", u.UserId, passwordKeyBytes)
+	}
+	return nil
+}
 
+// verify_password derives a hash from password and the user's salt and compares it in
+// constant time with the stored hash
+func verify_password(u User, password string) bool {
+	salt, err := hex.DecodeString(u.Salt)
+	if err != nil {
+		return false
+	}
+	expected, err := hex.DecodeString(u.Hash)
+	if err != nil || len(expected) != passwordKeyBytes {
+		return false
+	}
+	derived := pbkdf2([]byte(password), salt, passwordIterations, passwordKeyBytes)
+	return subtle.ConstantTimeCompare(derived, expected) == 1
 }
 
 //==============================================================================================================================
 //  Invoke Functions
 //==============================================================================================================================
-func (t *SimpleChaincode) reset_indexes(stub *shim.ChaincodeStub, args []string) ([]byte, error) {
+func (t *SimpleChaincode) reset_indexes(stub Stub, args []string) ([]byte, error) {
 	for _, i := range indexes {
-		// Marshal the index
-		var emptyIndex []string
-
-		empty, err := json.Marshal(emptyIndex)
+		err := clear_index(stub, i)
 		if err != nil {
-			return nil, errors.New("Error marshalling")
+			return nil, err
 		}
-		err = stub.PutState(i, empty);
+		logger.Infof("Delete with success from ledger: " + i)
 
+		err = audit(stub, AuditKindIndex, i, []string{i}, "", "")
 		if err != nil {
-			return nil, errors.New("Error deleting index")
+			return nil, err
 		}
-		logger.Infof("Delete with success from ledger: " + i)
 	}
 	return nil, nil
 }
 
-func (t *SimpleChaincode) add_user(stub *shim.ChaincodeStub, args []string) ([]byte, error) {
+// rebuild_indexes indexes the documents and requests written before their secondary indexes existed,
+// and moves the entity indexes still kept as a single array to their own keys
+func (t *SimpleChaincode) rebuild_indexes(stub Stub, args []string) ([]byte, error) {
+
+	for _, i := range indexes {
+		ids, err := migrate_index(stub, i)
+		if err != nil {
+			return nil, err
+		}
+		if ids > 0 {
+			logger.Infof("Migrated " + strconv.Itoa(ids) + " ids of " + i)
+		}
+	}
+
+	documents, err := t.document.RebuildIndexes(stub)
+	if err != nil {
+		return nil, err
+	}
+	requests, err := t.request.RebuildIndexes(stub)
+	if err != nil {
+		return nil, err
+	}
+
+	logger.Infof("Indexed " + strconv.Itoa(documents) + " documents and " + strconv.Itoa(requests) + " requests")
+	return nil, audit(stub, AuditKindIndex, "rebuild", []string{}, "", "")
+}
+
+func (t *SimpleChaincode) add_user(stub Stub, args []string) ([]byte, error) {
 
 	//Args
 	//			0				1
 	//		  index		user JSON object (as string)
 
-	id, err := append_id(stub, usersIndexStr, args[0], false)
+	if len(args) != 2 {
+		return nil, argCount("2")
+	}
+
+	var u User
+	err := json.Unmarshal([]byte(args[1]), &u)
+	if err != nil {
+		return nil, invalidArgument(ErrorDetails{"field": "user"}, "Invalid user JSON object")
+	}
+	if u.UserId != args[0] {
+		return nil, invalidArgument(ErrorDetails{"field": "userId"}, "User id does not match the index %s", args[0])
+	}
+	if _, err := read_user(stub, args[0]); err == nil {
+		return nil, alreadyExists(ErrorDetails{"kind": AuditKindUser, "userId": args[0]}, "User %s already exists.", args[0])
+	}
+	err = validate_credentials(u)
 	if err != nil {
-		return nil, errors.New("Error creating new id for user " + args[0])
+		return nil, err
+	}
+	if u.Role != "" && !(Caller{Role: u.Role}).HasRole(anyRole...) {
+		return nil, invalidArgument(ErrorDetails{"field": "role", "roles": anyRole}, "Invalid role %s for user %s", u.Role, args[0])
+	}
+	if u.Organisation != "" {
+		if _, err := get_organisation(stub, u.Organisation); err != nil {
+			return nil, err
+		}
 	}
+	u.Status = UserStatusActive
 
-	err = stub.PutState(string(id), []byte(args[1]))
+	userAsBytes, err := json.Marshal(u)
 	if err != nil {
-		return nil, errors.New("Error putting user data on ledger")
+		return nil, internalError(ErrorDetails{"kind": AuditKindUser, "userId": args[0]}, "Error marshalling user %s", args[0])
 	}
 
-	return nil, nil
+	err = put_index_entry(stub, usersIndexStr, args[0])
+	if err != nil {
+		return nil, err
+	}
+
+	err = stub.PutState(args[0], userAsBytes)
+	if err != nil {
+		return nil, internalError(ErrorDetails{"kind": AuditKindUser, "userId": args[0]}, "Error putting user data on ledger")
+	}
+
+	return nil, audit(stub, AuditKindUser, args[0], []string{args[0]}, "", u.Status)
+}
+
+// update_user changes the profile fields of a user. Users can only update their own record.
+func (t *SimpleChaincode) update_user(stub Stub, args []string) ([]byte, error) {
+
+	//Args
+	//			0				1
+	//		  userId		profile JSON object (as string), see UserProfile
+
+	if len(args) != 2 {
+		return nil, argCount("2")
+	}
+	if err := check_user_owner(stub, args[0]); err != nil {
+		return nil, err
+	}
+
+	var p UserProfile
+	dec := json.NewDecoder(strings.NewReader(args[1]))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&p); err != nil {
+		return nil, invalidArgument(ErrorDetails{"field": "profile"}, "Invalid profile: %s", err.Error())
+	}
+
+	u, err := read_user(stub, args[0])
+	if err != nil {
+		return nil, err
+	}
+	if p.FirstName != nil {
+		u.FirstName = *p.FirstName
+	}
+	if p.LastName != nil {
+		u.LastName = *p.LastName
+	}
+	if p.Address != nil {
+		u.Address = *p.Address
+	}
+	if p.PhoneNumber != nil {
+		u.PhoneNumber = *p.PhoneNumber
+	}
+	if p.EmailAddress != nil {
+		u.EmailAddress = *p.EmailAddress
+	}
+
+	if err := write_user(stub, u); err != nil {
+		return nil, err
+	}
+	return nil, audit(stub, AuditKindUser, u.UserId, []string{u.UserId}, u.Status, u.Status)
+}
+
+// deactivate_user stops a user from authenticating and invoking functions, until it is reactivated
+func (t *SimpleChaincode) deactivate_user(stub Stub, args []string) ([]byte, error) {
+
+	//Args
+	//			0
+	//		  userId
+
+	if len(args) != 1 {
+		return nil, argCount("1")
+	}
+	c, err := get_caller(stub)
+	if err != nil {
+		return nil, err
+	}
+	if c.UserId == args[0] {
+		return nil, forbidden(ErrorDetails{"userId": args[0]}, "Users can not deactivate themselves")
+	}
+	return nil, set_user_status(stub, args[0], UserStatusActive, UserStatusDeactivated)
+}
+
+func (t *SimpleChaincode) reactivate_user(stub Stub, args []string) ([]byte, error) {
+
+	//Args
+	//			0
+	//		  userId
+
+	if len(args) != 1 {
+		return nil, argCount("1")
+	}
+	return nil, set_user_status(stub, args[0], UserStatusDeactivated, UserStatusActive)
+}
+
+// change_password replaces the password of a user once the current password has been verified, as authenticate
+// verifies it. The arguments of an invoke are kept in the ledger, so both passwords are passed in the transient
+// data of the transaction. The client picks the new salt; the chaincode derives the new hash from it.
+// Users can only change their own password.
+func (t *SimpleChaincode) change_password(stub Stub, args []string) ([]byte, error) {
+
+	//Args
+	//			0			1
+	//		  userId	  salt
+	//Transient
+	//		  currentPassword	newPassword
+
+	if len(args) != 2 {
+		return nil, argCount("2")
+	}
+	if err := check_user_owner(stub, args[0]); err != nil {
+		return nil, err
+	}
+
+	transient, err := stub.GetTransient()
+	if err != nil {
+		return nil, internalError(ErrorDetails{"userId": args[0]}, "Failed to get the transient data: %s", err.Error())
+	}
+	current, next := string(transient[transientCurrentPassword]), string(transient[transientNewPassword])
+	if next == "" {
+		return nil, invalidArgument(ErrorDetails{"field": transientNewPassword}, "The new password of user %s must be passed in the transient data", args[0])
+	}
+
+	u, err := read_user(stub, args[0])
+	if err != nil {
+		return nil, err
+	}
+	if !verify_password(u, current) {
+		return nil, forbidden(ErrorDetails{"userId": u.UserId}, "Wrong current password for user %s", u.UserId)
+	}
+	if strings.EqualFold(args[1], u.Salt) {
+		return nil, invalidArgument(ErrorDetails{"field": "salt"}, "Invalid salt for user %s: the new password needs a new salt", u.UserId)
+	}
+
+	salt, err := hex.DecodeString(args[1])
+	if err != nil || len(salt) < saltMinBytes {
+		return nil, invalidArgument(ErrorDetails{"field": "salt"}, "Invalid salt for user %s: expecting at least %d hex encoded bytes", u.UserId, saltMinBytes)
+	}
+	u.Salt = hex.EncodeToString(salt)
+	u.Hash = hex.EncodeToString(pbkdf2([]byte(next), salt, passwordIterations, passwordKeyBytes))
+
+	if err := write_user(stub, u); err != nil {
+		return nil, err
+	}
+	return nil, audit(stub, AuditKindUser, u.UserId, []string{u.UserId}, u.Status, u.Status)
+}
+
+// check_user_owner returns an error unless the caller is the user userID or an admin
+func check_user_owner(stub Stub, userID string) error {
+	c, err := get_caller(stub)
+	if err != nil {
+		return err
+	}
+	if !c.HasRole(RoleAdmin) && c.UserId != userID {
+		return forbidden(ErrorDetails{"userId": userID}, "Users can only change their own record")
+	}
+	return nil
+}
+
+// set_user_status moves a user from status from to status to
+func set_user_status(stub Stub, userID string, from string, to string) error {
+	u, err := read_user(stub, userID)
+	if err != nil {
+		return err
+	}
+	if u.Status != from {
+		return invalidTransition(ErrorDetails{"kind": AuditKindUser, "userId": userID, "status": u.Status, "expecting": from}, "User %s is %s. Expecting %s.", userID, u.Status, from)
+	}
+	u.Status = to
+
+	if err := write_user(stub, u); err != nil {
+		return err
+	}
+	return audit(stub, AuditKindUser, userID, []string{userID}, from, to)
+}
+
+// write_user stores a user under its userId
+func write_user(stub Stub, u User) error {
+	userAsBytes, err := json.Marshal(u)
+	if err != nil {
+		return internalError(ErrorDetails{"kind": AuditKindUser, "userId": u.UserId}, "Error marshalling user %s", u.UserId)
+	}
+	if err := stub.PutState(u.UserId, userAsBytes); err != nil {
+		return internalError(ErrorDetails{"kind": AuditKindUser, "userId": u.UserId}, "Error putting user data on ledger")
+	}
+	return nil
 }
 
 //==============================================================================================================================
 //		Query Functions
 //==============================================================================================================================
 
-func (t *SimpleChaincode) get_user(stub *shim.ChaincodeStub, userID string) ([]byte, error) {
+func (t *SimpleChaincode) get_user(stub Stub, userID string) ([]byte, error) {
+
+	u, err := read_user(stub, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	// Never hand out the credentials
+	u.Salt = ""
+	u.Hash = ""
+
+	return json.Marshal(u)
+
+}
+
+// read_user returns the user stored under userID, including its credentials
+func read_user(stub Stub, userID string) (User, error) {
+
+	var u User
 
 	bytes, err := stub.GetState(userID)
+	if err != nil {
+		return u, internalError(ErrorDetails{"kind": AuditKindUser, "userId": userID}, "Could not retrieve information for user %s", userID)
+	}
+	if len(bytes) == 0 {
+		return u, notFound(ErrorDetails{"kind": AuditKindUser, "userId": userID}, "User %s does not exist.", userID)
+	}
 
+	err = json.Unmarshal(bytes, &u)
 	if err != nil {
-		return nil, errors.New("Could not retrieve information for this user")
+		return u, internalError(ErrorDetails{"kind": AuditKindUser, "userId": userID}, "Could not unmarshal information for user %s", userID)
+	}
+	if u.Status == "" {
+		u.Status = UserStatusActive
 	}
 
-	return bytes, nil
+	return u, nil
 
 }
 
-func (t *SimpleChaincode) authenticate(stub *shim.ChaincodeStub, args []string) ([]byte, error) {
+// list_users lists the registered users by userId, without their credentials. They can be filtered by
+// status, organisation and role.
+func (t *SimpleChaincode) list_users(stub Stub, args []string) ([]byte, error) {
+
+	if len(args) > 1 {
+		return nil, argCount("0 or 1")
+	}
+	o, err := listOptionsArg(args, 0)
+	if err != nil {
+		return nil, err
+	}
+	if o.CreatedFrom != "" || o.CreatedTo != "" || o.DocumentType != "" {
+		return nil, invalidArgument(ErrorDetails{"field": "options"}, "Invalid list options: users can only be filtered by status, organisation and role")
+	}
+	if o.Status != "" && o.Status != UserStatusActive && o.Status != UserStatusDeactivated {
+		return nil, invalidArgument(ErrorDetails{"field": "options", "status": o.Status}, "Invalid list options: unknown user status %s", o.Status)
+	}
+
+	ids, err := list_index(stub, usersIndexStr)
+	if err != nil {
+		return nil, err
+	}
+
+	// Filtering reads every user, otherwise only the users of the page are read
+	if o.Status != "" || o.Organisation != "" || o.Role != "" {
+		var matching []string
+		for _, id := range ids {
+			u, err := read_user(stub, id)
+			if err != nil {
+				return nil, err
+			}
+			if (o.Status == "" || o.Status == u.Status) && (o.Organisation == "" || o.Organisation == u.Organisation) && (o.Role == "" || o.Role == u.Role) {
+				matching = append(matching, id)
+			}
+		}
+		ids = matching
+	}
+
+	keys := make([]listKey, len(ids))
+	for i, id := range ids {
+		keys[i] = listKey{id}
+	}
+	first, last, next, err := paginate(keys, o, false)
+	if err != nil {
+		return nil, err
+	}
+
+	users := []User{}
+	for _, id := range ids[first:last] {
+		u, err := read_user(stub, id)
+		if err != nil {
+			return nil, err
+		}
+		u.Salt = ""
+		u.Hash = ""
+		users = append(users, u)
+	}
+
+	return json.Marshal(ListResponse{Count: len(users), Data: users, NextCursor: next})
+}
+
+func (t *SimpleChaincode) authenticate(stub Stub, args []string) ([]byte, error) {
 
 	// Args
 	//	0		1
 	//	userId	password
 
-	var u User
+	if len(args) != 2 {
+		return nil, argCount("2")
+	}
 
 	username := args[0]
+	password := args[1]
 
-	user, err := t.get_user(stub, username)
+	u, err := read_user(stub, username)
 
 	// If user can not be found in ledgerstore, return authenticated false
 	if err != nil {
-		return []byte(`{ "authenticated": false }`), nil
+		return json.Marshal(AuthenticateResponse{Authenticated: false})
 	}
 
-	//Check if the user is an employee, if not return error message
-	err = json.Unmarshal(user, &u)
-	if err != nil {
-		return []byte(`{ "authenticated": false}`), nil
+	// Deactivated users can not authenticate, whatever the password
+	if u.Status == UserStatusDeactivated {
+		return json.Marshal(AuthenticateResponse{Authenticated: false})
 	}
 
-	// Marshal the user object
-	userAsBytes, err := json.Marshal(u)
-	if err != nil {
-		return []byte(`{ "authenticated": false}`), nil
+	// Wrong password, return authenticated false
+	if !verify_password(u, password) {
+		return json.Marshal(AuthenticateResponse{Authenticated: false})
 	}
 
-	// Return authenticated true, and include the user object
-	str := `{ "authenticated": true, "user": ` + string(userAsBytes) + `  }`
-
-	return []byte(str), nil
+	// Return authenticated true, and include the user object without its credentials
+	u.Salt = ""
+	u.Hash = ""
+	return json.Marshal(AuthenticateResponse{Authenticated: true, User: &u})
 }
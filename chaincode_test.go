@@ -0,0 +1,771 @@
+package main
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+//==============================================================================================================================
+//	 Test harness - Every test deploys the chaincode on a fresh MemStub and calls it through invoke and query, the
+//					way a peer would: each call runs in its own transaction, invokes are committed unless they fail
+//					and queries are always rolled back.
+//==============================================================================================================================
+
+func TestMain(m *testing.M) {
+	logger.SetLevel("WARNING")
+	os.Exit(m.Run())
+}
+
+type testChaincode struct {
+	t    *testing.T
+	cc   *SimpleChaincode
+	stub *MemStub
+	tx   int
+}
+
+// newTestChaincode deploys the chaincode on an empty ledger. The transactions are timestamped 2 January 2017.
+func newTestChaincode(t *testing.T) *testChaincode {
+	h := &testChaincode{t: t, cc: new(SimpleChaincode), stub: NewMemStub()}
+	h.stub.Time = time.Date(2017, 1, 2, 9, 0, 0, 0, time.UTC)
+
+	h.admin()
+	_, err := h.stub.Transaction(h.nextTx(), func() ([]byte, error) {
+		return h.cc.init(h.stub, "init", []string{})
+	})
+	if err != nil {
+		t.Fatalf("init: %s", err)
+	}
+	for _, o := range testOrganisations {
+		h.mustInvoke("register_organisation", o)
+	}
+	return h
+}
+
+// testOrganisations are the parties the test users act for
+var testOrganisations = []string{
+	`{"id": "BankA", "name": "Bank A", "type": "bank", "bic": "BKAAGB2L", "country": "GB"}`,
+	`{"id": "CorpB", "name": "Corp B Ltd", "type": "corporate", "lei": "5493001KJTIIGC8Y1R12", "country": "GB"}`,
+	`{"id": "SupplierC", "name": "Supplier C", "type": "corporate", "country": "DE"}`,
+}
+
+func (h *testChaincode) nextTx() string {
+	h.tx++
+	return fmt.Sprintf("tx%d", h.tx)
+}
+
+// as makes the following calls on behalf of the given user
+func (h *testChaincode) as(userId string, role string, party string) *testChaincode {
+	h.stub.Attributes = map[string]string{attrUserId: userId, attrRole: role}
+	if party != "" {
+		h.stub.Attributes[attrParty] = party
+	}
+	return h
+}
+
+// The parties of the tests: CorpB asks BankA for guarantees in favour of SupplierC
+func (h *testChaincode) applicant() *testChaincode {
+	return h.as("alice", RoleApplicant, "CorpB")
+}
+
+func (h *testChaincode) officer() *testChaincode {
+	return h.as("bob", RoleBankOfficer, "BankA")
+}
+
+func (h *testChaincode) beneficiary() *testChaincode {
+	return h.as("carol", RoleBeneficiary, "SupplierC")
+}
+
+func (h *testChaincode) auditor() *testChaincode {
+	return h.as("dave", RoleAuditor, "")
+}
+
+func (h *testChaincode) admin() *testChaincode {
+	return h.as("root", RoleAdmin, "")
+}
+
+// invoke calls an invoke function in a transaction of its own
+func (h *testChaincode) invoke(function string, args ...string) ([]byte, error) {
+	return h.stub.Transaction(h.nextTx(), func() ([]byte, error) {
+		return h.cc.invoke(h.stub, function, args)
+	})
+}
+
+// query calls a query function. Its transaction is rolled back, queries must not write.
+func (h *testChaincode) query(function string, args ...string) ([]byte, error) {
+	if err := h.stub.Begin(h.nextTx()); err != nil {
+		return nil, err
+	}
+	defer h.stub.Rollback()
+
+	return h.cc.query(h.stub, function, args)
+}
+
+func (h *testChaincode) mustInvoke(function string, args ...string) []byte {
+	h.t.Helper()
+	payload, err := h.invoke(function, args...)
+	if err != nil {
+		h.t.Fatalf("%s: %s", function, err)
+	}
+	return payload
+}
+
+func (h *testChaincode) mustQuery(function string, args ...string) []byte {
+	h.t.Helper()
+	payload, err := h.query(function, args...)
+	if err != nil {
+		h.t.Fatalf("%s: %s", function, err)
+	}
+	return payload
+}
+
+// mustQueryInto unmarshals the result of a query into v
+func (h *testChaincode) mustQueryInto(v interface{}, function string, args ...string) {
+	h.t.Helper()
+	if err := json.Unmarshal(h.mustQuery(function, args...), v); err != nil {
+		h.t.Fatalf("%s: %s", function, err)
+	}
+}
+
+// expectCode fails the test unless err is a ChaincodeError with the given code
+func expectCode(t *testing.T, err error, code string) {
+	t.Helper()
+	if err == nil {
+		t.Fatalf("expected a %s error, got none", code)
+	}
+	e, ok := err.(*ChaincodeError)
+	if !ok {
+		t.Fatalf("expected a %s error, got the untyped error %s", code, err)
+	}
+	if e.Code != code {
+		t.Fatalf("expected a %s error, got %s", code, err)
+	}
+}
+
+// listOf decodes a ListResponse, leaving its data for the test to decode
+type listOf struct {
+	Count      int             `json:"count"`
+	Data       json.RawMessage `json:"data"`
+	NextCursor string          `json:"nextCursor"`
+}
+
+//==============================================================================================================================
+//	 Fixtures
+//==============================================================================================================================
+
+const (
+	testExpiryDate = "2017-12-31"
+	testPassword   = "correct horse battery staple"
+)
+
+// lgDocJSON describes an LG for amount in favour of SupplierC
+func lgDocJSON(amount int64) string {
+	return fmt.Sprintf(`{"amount": %d, "beneficiary": "SupplierC", "expiryDate": "%s"}`, amount, testExpiryDate)
+}
+
+// submitRequest submits a request of CorpB to BankA for an LG
+func (h *testChaincode) submitRequest(uid string, amount int64) {
+	h.t.Helper()
+	h.applicant().mustInvoke("submit_new_request", RequestTypeNew, "CorpB", "BankA", uid, lgDocJSON(amount), StatusSubmitted, "{}")
+}
+
+// issueLG issues an LG of BankA to CorpB through a request approved by BankA. The LG has the uid of the request.
+func (h *testChaincode) issueLG(uid string, amount int64) {
+	h.t.Helper()
+	h.submitRequest(uid, amount)
+	h.officer().mustInvoke("approve_new_request", "CorpB", "BankA", uid)
+}
+
+// testUser returns the JSON of a user whose password is testPassword
+func testUser(userId string) string {
+	salt := []byte("0123456789abcdef")
+	hash := pbkdf2([]byte(testPassword), salt, passwordIterations, passwordKeyBytes)
+	u := User{
+		UserId:    userId,
+		Salt:      hex.EncodeToString(salt),
+		Hash:      hex.EncodeToString(hash),
+		FirstName: "Test",
+		Things:    []string{},
+	}
+	userAsBytes, _ := json.Marshal(u)
+	return string(userAsBytes)
+}
+
+//==============================================================================================================================
+//	 Administration and users
+//==============================================================================================================================
+
+func TestInitIsIdempotent(t *testing.T) {
+	h := newTestChaincode(t)
+	h.issueLG("R1", 1000)
+
+	h.admin().mustInvoke("init")
+
+	var d DocumentRecord
+	h.officer().mustQueryInto(&d, "get_lg_by_uid", "R1")
+	if d.Status != DocStatusIssued {
+		t.Fatalf("init changed the documents: %+v", d)
+	}
+	var types listOf
+	h.mustQueryInto(&types, "get_document_types")
+	if types.Count != len(defaultDocumentTypes) {
+		t.Fatalf("expected %d document types, got %d", len(defaultDocumentTypes), types.Count)
+	}
+}
+
+func TestInitReturnsErrors(t *testing.T) {
+	s := NewMemStub()
+
+	// A ledger whose DocumentTypeTable has lost its Definition column
+	s.CreateTable("DocumentTypeTable", []*ColumnDefinition{&ColumnDefinition{Name: "Type", Type: ColumnDefinition_STRING, Key: true}})
+	_, err := s.Transaction("tx1", func() ([]byte, error) {
+		return new(SimpleChaincode).init(s, "init", []string{})
+	})
+	if err == nil {
+		t.Fatal("expected init to fail")
+	}
+}
+
+func TestInvokeChecksRoleAndArguments(t *testing.T) {
+	h := newTestChaincode(t)
+
+	_, err := h.applicant().invoke("add_user", "erin", testUser("erin"))
+	expectCode(t, err, CodeForbidden)
+
+	_, err = h.admin().invoke("add_user", "erin")
+	expectCode(t, err, CodeInvalidArgument)
+
+	_, err = h.invoke("no_such_function")
+	expectCode(t, err, CodeInvalidArgument)
+
+	_, err = h.query("add_user", "erin", testUser("erin"))
+	expectCode(t, err, CodeInvalidArgument)
+}
+
+func TestNamedArguments(t *testing.T) {
+	h := newTestChaincode(t)
+	h.submitRequest("R1", 1000)
+
+	var r RequestRecord
+	h.applicant().mustQueryInto(&r, "get_request_json", namedArgsMarker, `{"apiVersion": "1", "requester": "CorpB", "approver": "BankA", "uid": "R1"}`)
+	if r.Uid != "R1" || r.RequestType != RequestTypeNew {
+		t.Fatalf("unexpected request %+v", r)
+	}
+
+	_, err := h.query("get_request_json", namedArgsMarker, `{"apiVersion": "1", "requester": "CorpB", "approver": "BankA", "uid": "R1", "colour": "red"}`)
+	expectCode(t, err, CodeInvalidArgument)
+	_, err = h.query("get_request_json", namedArgsMarker, `{"apiVersion": "2", "requester": "CorpB", "approver": "BankA", "uid": "R1"}`)
+	expectCode(t, err, CodeInvalidArgument)
+	_, err = h.query("get_request_json", namedArgsMarker, `["CorpB", "BankA", "R1"]`)
+	expectCode(t, err, CodeInvalidArgument)
+	_, err = h.query("get_request_json", namedArgsMarker, `{"apiVersion": "1"}`, "R1")
+	expectCode(t, err, CodeInvalidArgument)
+
+	// Without the marker, a JSON object is a positional argument like any other
+	_, err = h.auditor().query("get_request_by_uid", `{"apiVersion": "1", "uid": "R1"}`)
+	expectCode(t, err, CodeNotFound)
+}
+
+func TestAddAndGetUser(t *testing.T) {
+	h := newTestChaincode(t)
+	h.admin().mustInvoke("add_user", "alice", testUser("alice"))
+
+	_, err := h.invoke("add_user", "alice", testUser("alice"))
+	expectCode(t, err, CodeAlreadyExists)
+	_, err = h.invoke("add_user", "bob", testUser("alice"))
+	expectCode(t, err, CodeInvalidArgument)
+
+	var u User
+	h.applicant().mustQueryInto(&u, "get_user", "alice", "alice")
+	if u.UserId != "alice" || u.Salt != "" || u.Hash != "" {
+		t.Fatalf("expected alice without credentials, got %+v", u)
+	}
+
+	_, err = h.officer().query("get_user", "alice", "alice")
+	expectCode(t, err, CodeForbidden)
+	_, err = h.admin().query("get_user", "erin", "erin")
+	expectCode(t, err, CodeNotFound)
+
+	// A record that can not be read is reported as an internal error, with the user it concerns
+	h.stub.PutState("frank", []byte("not json"))
+	_, err = h.query("get_user", "frank", "frank")
+	expectCode(t, err, CodeInternal)
+	if !strings.Contains(err.Error(), `"userId":"frank"`) {
+		t.Fatalf("expected the details to name frank, got %s", err)
+	}
+}
+
+func TestAuthenticate(t *testing.T) {
+	h := newTestChaincode(t)
+	h.admin().mustInvoke("add_user", "alice", testUser("alice"))
+
+	var res AuthenticateResponse
+	h.applicant().mustQueryInto(&res, "authenticate", "alice", testPassword)
+	if !res.Authenticated || res.User == nil || res.User.Hash != "" {
+		t.Fatalf("expected alice to be authenticated without credentials, got %+v", res)
+	}
+
+	res = AuthenticateResponse{}
+	h.mustQueryInto(&res, "authenticate", "alice", "wrong")
+	if res.Authenticated || res.User != nil {
+		t.Fatalf("expected a wrong password to fail, got %+v", res)
+	}
+
+	res = AuthenticateResponse{}
+	h.mustQueryInto(&res, "authenticate", "erin", testPassword)
+	if res.Authenticated {
+		t.Fatal("expected an unknown user to fail")
+	}
+}
+
+func TestUpdateUser(t *testing.T) {
+	h := newTestChaincode(t)
+	h.admin().mustInvoke("add_user", "alice", testUser("alice"))
+
+	h.applicant().mustInvoke("update_user", "alice", `{"lastName": "Liddell", "emailAddress": "alice@corpb.example"}`)
+	h.admin().mustInvoke("update_user", "alice", `{"phoneNumber": "+44 20 7946 0000"}`)
+
+	var u User
+	h.applicant().mustQueryInto(&u, "get_user", "alice", "alice")
+	if u.FirstName != "Test" || u.LastName != "Liddell" || u.EmailAddress != "alice@corpb.example" || u.PhoneNumber == "" {
+		t.Fatalf("unexpected user %+v", u)
+	}
+
+	_, err := h.applicant().invoke("update_user", "alice", `{"role": "admin"}`)
+	expectCode(t, err, CodeInvalidArgument)
+	_, err = h.officer().invoke("update_user", "alice", `{"lastName": "Smith"}`)
+	expectCode(t, err, CodeForbidden)
+	_, err = h.admin().invoke("update_user", "erin", `{"lastName": "Smith"}`)
+	expectCode(t, err, CodeNotFound)
+}
+
+func TestDeactivateUser(t *testing.T) {
+	h := newTestChaincode(t)
+	h.admin().mustInvoke("add_user", "alice", testUser("alice"))
+	h.mustInvoke("deactivate_user", "alice")
+
+	var res AuthenticateResponse
+	h.applicant().mustQueryInto(&res, "authenticate", "alice", testPassword)
+	if res.Authenticated {
+		t.Fatal("a deactivated user authenticated")
+	}
+	_, err := h.applicant().invoke("submit_new_request", RequestTypeNew, "CorpB", "BankA", "R1", lgDocJSON(1000), StatusSubmitted, "{}")
+	expectCode(t, err, CodeForbidden)
+	_, err = h.admin().invoke("deactivate_user", "alice")
+	expectCode(t, err, CodeInvalidTransition)
+	_, err = h.invoke("deactivate_user", "root")
+	expectCode(t, err, CodeForbidden)
+
+	h.mustInvoke("reactivate_user", "alice")
+	h.applicant().mustQueryInto(&res, "authenticate", "alice", testPassword)
+	if !res.Authenticated || res.User.Status != UserStatusActive {
+		t.Fatalf("expected alice to be active again, got %+v", res)
+	}
+	h.submitRequest("R1", 1000)
+
+	_, err = h.admin().invoke("reactivate_user", "alice")
+	expectCode(t, err, CodeInvalidTransition)
+	_, err = h.officer().invoke("reactivate_user", "alice")
+	expectCode(t, err, CodeForbidden)
+}
+
+// changePassword passes the passwords of change_password in the transient data
+func (h *testChaincode) changePassword(userId string, salt string, current string, next string) error {
+	h.t.Helper()
+	h.stub.Transient = map[string][]byte{transientCurrentPassword: []byte(current), transientNewPassword: []byte(next)}
+	defer func() { h.stub.Transient = nil }()
+	_, err := h.invoke("change_password", userId, salt)
+	return err
+}
+
+func TestChangePassword(t *testing.T) {
+	h := newTestChaincode(t)
+	h.admin().mustInvoke("add_user", "alice", testUser("alice"))
+
+	salt := hex.EncodeToString([]byte("fedcba9876543210"))
+	if err := h.applicant().changePassword("alice", salt, testPassword, "new password"); err != nil {
+		t.Fatal(err)
+	}
+
+	var res AuthenticateResponse
+	h.mustQueryInto(&res, "authenticate", "alice", testPassword)
+	if res.Authenticated {
+		t.Fatal("the old password still authenticates")
+	}
+	h.mustQueryInto(&res, "authenticate", "alice", "new password")
+	if !res.Authenticated {
+		t.Fatal("the new password does not authenticate")
+	}
+
+	// The passwords never reach the arguments of the transaction
+	salt = hex.EncodeToString([]byte("0123456789abcdef"))
+	_, err := h.invoke("change_password", "alice", salt)
+	expectCode(t, err, CodeInvalidArgument)
+	_, err = h.invoke("change_password", "alice", "new password", "another password")
+	expectCode(t, err, CodeInvalidArgument)
+
+	expectCode(t, h.changePassword("alice", salt, testPassword, "another password"), CodeForbidden)
+	expectCode(t, h.changePassword("alice", hex.EncodeToString([]byte("fedcba9876543210")), "new password", "another password"), CodeInvalidArgument)
+	expectCode(t, h.changePassword("alice", "00", "new password", "another password"), CodeInvalidArgument)
+	expectCode(t, h.officer().changePassword("alice", salt, "new password", "another password"), CodeForbidden)
+}
+
+func TestListUsers(t *testing.T) {
+	h := newTestChaincode(t)
+	for _, id := range []string{"carol", "alice", "bob"} {
+		h.admin().mustInvoke("add_user", id, testUser(id))
+	}
+
+	var page listOf
+	h.auditor().mustQueryInto(&page, "list_users", `{"pageSize": 2}`)
+	var users []User
+	json.Unmarshal(page.Data, &users)
+	if page.Count != 2 || users[0].UserId != "alice" || users[1].UserId != "bob" || page.NextCursor == "" {
+		t.Fatalf("unexpected first page %+v", page)
+	}
+	if users[0].Hash != "" {
+		t.Fatal("list_users returned credentials")
+	}
+
+	h.mustQueryInto(&page, "list_users", `{"pageSize": 2, "cursor": "`+page.NextCursor+`"}`)
+	json.Unmarshal(page.Data, &users)
+	if page.Count != 1 || users[0].UserId != "carol" || page.NextCursor != "" {
+		t.Fatalf("unexpected last page %+v", page)
+	}
+
+	_, err := h.query("list_users", `{"status": "issued"}`)
+	expectCode(t, err, CodeInvalidArgument)
+	_, err = h.query("list_users", `{"documentType": "LG"}`)
+	expectCode(t, err, CodeInvalidArgument)
+}
+
+func TestListUsersFilters(t *testing.T) {
+	h := newTestChaincode(t)
+	h.admin().mustInvoke("register_organisation", `{"id": "BankE", "name": "Bank E", "type": "bank", "bic": "BKEEGB2L", "country": "GB"}`)
+	for _, u := range []struct{ id, role, organisation string }{
+		{"alice", RoleApplicant, "CorpB"}, {"bob", RoleBankOfficer, "BankA"}, {"erin", RoleBankOfficer, "BankA"}, {"frank", RoleBankOfficer, "BankE"},
+	} {
+		var user User
+		json.Unmarshal([]byte(testUser(u.id)), &user)
+		user.Role = u.role
+		user.Organisation = u.organisation
+		userAsBytes, _ := json.Marshal(user)
+		h.admin().mustInvoke("add_user", u.id, string(userAsBytes))
+	}
+	h.mustInvoke("deactivate_user", "erin")
+
+	var page listOf
+	var users []User
+	h.auditor().mustQueryInto(&page, "list_users", `{"organisation": "BankA", "role": "bank_officer", "pageSize": 1}`)
+	json.Unmarshal(page.Data, &users)
+	if page.Count != 1 || users[0].UserId != "bob" || page.NextCursor == "" {
+		t.Fatalf("unexpected first page %+v", page)
+	}
+	h.mustQueryInto(&page, "list_users", `{"organisation": "BankA", "role": "bank_officer", "pageSize": 1, "cursor": "`+page.NextCursor+`"}`)
+	json.Unmarshal(page.Data, &users)
+	if page.Count != 1 || users[0].UserId != "erin" || page.NextCursor != "" {
+		t.Fatalf("unexpected last page %+v", page)
+	}
+
+	h.mustQueryInto(&page, "list_users", `{"role": "bank_officer", "status": "active"}`)
+	json.Unmarshal(page.Data, &users)
+	if page.Count != 2 || users[0].UserId != "bob" || users[1].UserId != "frank" {
+		t.Fatalf("unexpected active officers %+v", users)
+	}
+
+	_, err := h.admin().invoke("add_user", "gina", strings.Replace(testUser("gina"), `"role":""`, `"role":"root"`, 1))
+	expectCode(t, err, CodeInvalidArgument)
+}
+
+func TestResetIndexes(t *testing.T) {
+	h := newTestChaincode(t)
+	h.admin().mustInvoke("add_user", "alice", testUser("alice"))
+
+	h.mustInvoke("reset_indexes")
+
+	var page listOf
+	h.mustQueryInto(&page, "list_users")
+	if page.Count != 0 {
+		t.Fatalf("expected no users after reset_indexes, got %d", page.Count)
+	}
+
+	// The users themselves are kept
+	_, err := h.invoke("add_user", "alice", testUser("alice"))
+	expectCode(t, err, CodeAlreadyExists)
+}
+
+func TestRebuildIndexesMigratesUsers(t *testing.T) {
+	h := newTestChaincode(t)
+
+	// A ledger written when the users index was a single array
+	h.stub.Transaction(h.nextTx(), func() ([]byte, error) {
+		h.stub.PutState("alice", []byte(testUser("alice")))
+		h.stub.PutState("bob", []byte(testUser("bob")))
+		return nil, h.stub.PutState(usersIndexStr, []byte(`["bob","alice"]`))
+	})
+
+	var page listOf
+	h.admin().mustQueryInto(&page, "list_users")
+	if page.Count != 0 {
+		t.Fatalf("expected no users before the migration, got %d", page.Count)
+	}
+
+	h.mustInvoke("rebuild_indexes")
+	h.mustInvoke("rebuild_indexes")
+
+	var users []User
+	h.mustQueryInto(&page, "list_users")
+	json.Unmarshal(page.Data, &users)
+	if page.Count != 2 || users[0].UserId != "alice" || users[1].UserId != "bob" {
+		t.Fatalf("unexpected users %+v", users)
+	}
+	if v, _ := h.stub.GetState(usersIndexStr); len(v) != 0 {
+		t.Fatalf("legacy index not deleted: %s", v)
+	}
+	_, err := h.invoke("add_user", "alice", testUser("alice"))
+	expectCode(t, err, CodeAlreadyExists)
+}
+
+func TestRebuildIndexes(t *testing.T) {
+	h := newTestChaincode(t)
+	h.issueLG("R1", 1000)
+
+	// Forget the UID and expiry indexes, as on a ledger written before they existed
+	h.stub.DeleteTable(documentUidIndex)
+	h.stub.DeleteTable(requestUidIndex)
+	h.stub.DeleteTable(expiryIndex)
+	h.admin().mustInvoke("init")
+
+	_, err := h.officer().query("get_lg_by_uid", "R1")
+	expectCode(t, err, CodeNotFound)
+	h.stub.Time = time.Date(2018, 1, 1, 0, 0, 0, 0, time.UTC)
+	var expired listOf
+	json.Unmarshal(h.admin().mustInvoke("expire_documents"), &expired)
+	if expired.Count != 0 {
+		t.Fatalf("expected no expiry index entries, got %+v", expired)
+	}
+
+	h.admin().mustInvoke("rebuild_indexes")
+	json.Unmarshal(h.admin().mustInvoke("expire_documents"), &expired)
+	if expired.Count != 1 {
+		t.Fatalf("expected R1 to expire once indexed, got %+v", expired)
+	}
+
+	var d DocumentRecord
+	h.officer().mustQueryInto(&d, "get_lg_by_uid", "R1")
+	var r RequestRecord
+	h.mustQueryInto(&r, "get_request_by_uid", "R1")
+	if d.Uid != "R1" || r.Uid != "R1" {
+		t.Fatalf("indexes not rebuilt: %+v %+v", d, r)
+	}
+}
+
+func TestListFunctions(t *testing.T) {
+	h := newTestChaincode(t)
+
+	var page struct {
+		Count int            `json:"count"`
+		Data  []FunctionSpec `json:"data"`
+	}
+	h.beneficiary().mustQueryInto(&page, "list_functions")
+	if page.Count != len(functions) || page.Data[0].Name != "init" {
+		t.Fatalf("unexpected registry %+v", page)
+	}
+}
+
+// Every registered function is complete, declares its optional arguments last and is reached through its
+// kind, where the registry refuses too many arguments before its handler runs
+func TestFunctionRegistry(t *testing.T) {
+	h := newTestChaincode(t)
+
+	seen := map[string]bool{}
+	for _, f := range functions {
+		key := f.Kind + "/" + f.Name
+		if seen[key] {
+			t.Errorf("%s is registered twice", key)
+		}
+		seen[key] = true
+		if f.handler == nil || f.Description == "" || len(f.Roles) == 0 {
+			t.Errorf("%s lacks a handler, description or role", key)
+		}
+		optional := false
+		for _, a := range f.Args {
+			if optional && !a.Optional {
+				t.Errorf("%s: required argument %s follows an optional one", key, a.Name)
+			}
+			if a.Default != "" && !a.Optional {
+				t.Errorf("%s: required argument %s has a default", key, a.Name)
+			}
+			optional = optional || a.Optional
+		}
+
+		call := h.as("tester", f.Roles[0], "BankA").invoke
+		if f.Kind == KindQuery {
+			call = h.query
+		}
+		_, err := call(f.Name, make([]string, len(f.Args)+1)...)
+		if !has_code(err, CodeInvalidArgument) || !strings.Contains(err.Error(), "Incorrect number of arguments") {
+			t.Errorf("%s: expected the registry to refuse %d arguments, got %v", key, len(f.Args)+1, err)
+		}
+	}
+}
+
+//==============================================================================================================================
+//	 Document types and schemas
+//==============================================================================================================================
+
+func TestRegisterDocumentType(t *testing.T) {
+	h := newTestChaincode(t)
+
+	h.admin().mustInvoke("register_document_type", `{"type": "SHIPPING_GUARANTEE", "name": "Shipping guarantee", "requiredFields": ["vessel"], "operations": ["issue", "cancel"]}`)
+
+	var page listOf
+	h.officer().mustQueryInto(&page, "get_document_types", `{"documentType": "SHIPPING_GUARANTEE"}`)
+	var types []DocumentTypeDef
+	json.Unmarshal(page.Data, &types)
+	if page.Count != 1 || types[0].Name != "Shipping guarantee" {
+		t.Fatalf("unexpected document types %+v", page)
+	}
+
+	// Required fields and operations are enforced
+	_, err := h.invoke("issue_document", "CorpB", "BankA", "SHIPPING_GUARANTEE", "D1", `{"amount": 10}`, DocStatusIssued, "{}", testExpiryDate)
+	expectCode(t, err, CodeInvalidArgument)
+	h.mustInvoke("issue_document", "CorpB", "BankA", "SHIPPING_GUARANTEE", "D1", `{"amount": 10, "vessel": "Ever Given"}`, DocStatusIssued, "{}", testExpiryDate)
+	_, err = h.beneficiary().invoke("submit_claim", "CorpB", "BankA", "D1", "C1", "5", "Unpaid", "SHIPPING_GUARANTEE")
+	expectCode(t, err, CodeForbidden)
+
+	_, err = h.admin().invoke("register_document_type", `{"type": "X", "operations": ["print"]}`)
+	expectCode(t, err, CodeInvalidArgument)
+	_, err = h.officer().invoke("issue_document", "CorpB", "BankA", "UNKNOWN", "D2", `{}`, DocStatusIssued, "{}", testExpiryDate)
+	expectCode(t, err, CodeNotFound)
+}
+
+func TestRegisterSchema(t *testing.T) {
+	h := newTestChaincode(t)
+	schema := `{"type": "object", "required": ["amount"], "properties": {"amount": {"type": "integer", "minimum": 1}}}`
+
+	h.admin().mustInvoke("register_schema", "LG", schema)
+
+	var got map[string]interface{}
+	h.officer().mustQueryInto(&got, "get_schema", "LG")
+	if got["type"] != "object" {
+		t.Fatalf("unexpected schema %v", got)
+	}
+
+	_, err := h.invoke("issue_document", "CorpB", "BankA", "LG", "D1", `{"amount": 0}`, DocStatusIssued, "{}", testExpiryDate)
+	expectCode(t, err, CodeInvalidArgument)
+	if !strings.Contains(err.Error(), "$.amount: lower than the minimum 1") || err.(*ChaincodeError).Details["field"] != "DataJSON" {
+		t.Fatalf("expected the violation to be reported against DataJSON, got %s", err)
+	}
+
+	// A request is checked on submission, the error names its DocJSON
+	_, err = h.applicant().invoke("submit_new_request", RequestTypeNew, "CorpB", "BankA", "R1", lgDocJSON(0), StatusSubmitted, "{}")
+	expectCode(t, err, CodeInvalidArgument)
+	if !strings.Contains(err.Error(), "Invalid DocJSON") || err.(*ChaincodeError).Details["field"] != "DocJSON" {
+		t.Fatalf("expected the violation to be reported against DocJSON, got %s", err)
+	}
+	h.officer().mustInvoke("issue_document", "CorpB", "BankA", "LG", "D1", `{"amount": 1}`, DocStatusIssued, "{}", testExpiryDate)
+
+	_, err = h.admin().invoke("register_schema", "LG", `{"type": "object", "format": "email"}`)
+	expectCode(t, err, CodeInvalidArgument)
+	_, err = h.invoke("register_schema", "UNKNOWN", schema)
+	expectCode(t, err, CodeNotFound)
+}
+
+//==============================================================================================================================
+//	 Audit trail
+//==============================================================================================================================
+
+func TestGetAuditTrail(t *testing.T) {
+	h := newTestChaincode(t)
+	h.issueLG("R1", 1000)
+
+	var page listOf
+	h.applicant().mustQueryInto(&page, "get_audit_trail", AuditKindRequest, "R1")
+	var entries []AuditEntry
+	json.Unmarshal(page.Data, &entries)
+	if page.Count != 2 {
+		t.Fatalf("expected 2 entries, got %+v", page)
+	}
+	if entries[0].Function != "submit_new_request" || entries[0].UserId != "alice" || entries[0].NewStatus != StatusSubmitted {
+		t.Fatalf("unexpected first entry %+v", entries[0])
+	}
+	if entries[1].Function != "approve_new_request" || entries[1].OldStatus != StatusSubmitted || entries[1].NewStatus != StatusApproved {
+		t.Fatalf("unexpected second entry %+v", entries[1])
+	}
+
+	h.officer().mustQueryInto(&page, "get_audit_trail", AuditKindDocument, "R1")
+	if page.Count != 1 {
+		t.Fatalf("expected 1 document entry, got %+v", page)
+	}
+
+	h.admin().mustInvoke("register_document_type", `{"type": "SHIPPING_GUARANTEE"}`)
+	_, err := h.applicant().query("get_audit_trail", AuditKindDocumentType, "SHIPPING_GUARANTEE")
+	expectCode(t, err, CodeForbidden)
+	_, err = h.as("eve", RoleApplicant, "CorpE").query("get_audit_trail", AuditKindRequest, "R1")
+	expectCode(t, err, CodeForbidden)
+
+	h.auditor().mustQueryInto(&page, "get_audit_trail", AuditKindDocumentType, "SHIPPING_GUARANTEE")
+	if page.Count != 1 {
+		t.Fatalf("expected the registration to be audited, got %+v", page)
+	}
+	// A transaction does not read its own entries back, yet numbers them apart
+	h.admin().stub.Transaction(h.nextTx(), func() ([]byte, error) {
+		if err := audit(h.stub, AuditKindIndex, "twice", []string{}, "", ""); err != nil {
+			return nil, err
+		}
+		return nil, audit(h.stub, AuditKindIndex, "twice", []string{}, "", "")
+	})
+	h.auditor().mustQueryInto(&page, "get_audit_trail", AuditKindIndex, "twice")
+	if page.Count != 2 {
+		t.Fatalf("expected 2 entries written by one transaction, got %+v", page)
+	}
+}
+
+//==============================================================================================================================
+//	 Transactions
+//==============================================================================================================================
+
+func TestFailedInvokeIsRolledBack(t *testing.T) {
+	h := newTestChaincode(t)
+	h.submitRequest("R1", 1000)
+	events := len(h.stub.Events)
+
+	// The request is approved before the LG fails to issue: both must be discarded
+	h.officer().mustInvoke("issue_document", "CorpB", "BankA", "LG", "R1", `{"amount": 1}`, DocStatusIssued, "{}", testExpiryDate)
+	_, err := h.invoke("approve_new_request", "CorpB", "BankA", "R1")
+	expectCode(t, err, CodeAlreadyExists)
+
+	var r RequestRecord
+	h.mustQueryInto(&r, "get_request_json", "CorpB", "BankA", "R1")
+	if r.Status != StatusSubmitted {
+		t.Fatalf("expected the failed approval to be rolled back, request is %s", r.Status)
+	}
+	if len(h.stub.Events) != events+1 {
+		t.Fatalf("expected only the issue event to be published, got %+v", h.stub.Events[events:])
+	}
+}
+
+func TestEvents(t *testing.T) {
+	h := newTestChaincode(t)
+	h.issueLG("R1", 1000)
+
+	last := h.stub.Events[len(h.stub.Events)-1]
+	if last.Name != EventDocumentIssued {
+		t.Fatalf("expected %s, got %s", EventDocumentIssued, last.Name)
+	}
+	var payload EventPayload
+	if err := json.Unmarshal(last.Payload, &payload); err != nil {
+		t.Fatal(err)
+	}
+	if payload.Version != eventVersion || len(payload.Transitions) != 2 {
+		t.Fatalf("unexpected payload %+v", payload)
+	}
+	if payload.Transitions[0].Name != EventRequestApproved || payload.Transitions[0].TxId != last.TxID {
+		t.Fatalf("unexpected first transition %+v", payload.Transitions[0])
+	}
+}
@@ -0,0 +1,443 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+)
+
+// Claim models the demands for payment a beneficiary makes against an issued document
+type Claim struct {
+}
+
+// Claim statuses
+const (
+	ClaimStatusSubmitted     = "submitted"
+	ClaimStatusPaid          = "paid"
+	ClaimStatusPartiallyPaid = "partially_paid"
+	ClaimStatusRejected      = "rejected"
+)
+
+// ClaimDecision records one decision of the issuer on a claim
+type ClaimDecision struct {
+	Decision  string `json:"decision"`
+	Amount    int64  `json:"amount"`
+	Reason    string `json:"reason"`
+	DecidedBy string `json:"decidedBy"`
+	DecidedAt string `json:"decidedAt"`
+}
+
+// ClaimRecord is a row of the ClaimTable
+type ClaimRecord struct {
+	DocumentUid  string          `json:"documentUid"`
+	Uid          string          `json:"uid"`
+	Owner        string          `json:"owner"`
+	Issuer       string          `json:"issuer"`
+	DocumentType string          `json:"documentType"`
+	Beneficiary  string          `json:"beneficiary"`
+	Amount       int64           `json:"amount"`
+	Statement    string          `json:"statement"`
+	Status       string          `json:"status"`
+	PaidAmount   int64           `json:"paidAmount"`
+	Decisions    []ClaimDecision `json:"decisions"`
+	CreatedAt    string          `json:"createdAt"`
+}
+
+// claimFromRow converts a ClaimTable row to a ClaimRecord
+func claimFromRow(row Row) (ClaimRecord, error) {
+	c := ClaimRecord{
+		DocumentUid:  row.Columns[0].GetString_(),
+		Uid:          row.Columns[1].GetString_(),
+		Owner:        row.Columns[2].GetString_(),
+		Issuer:       row.Columns[3].GetString_(),
+		DocumentType: row.Columns[4].GetString_(),
+		Beneficiary:  row.Columns[5].GetString_(),
+		Amount:       row.Columns[6].GetInt64(),
+		Statement:    row.Columns[7].GetString_(),
+		Status:       row.Columns[8].GetString_(),
+		PaidAmount:   row.Columns[9].GetInt64(),
+		CreatedAt:    row.Columns[11].GetString_(),
+	}
+	err := json.Unmarshal(row.Columns[10].GetBytes(), &c.Decisions)
+	if err != nil {
+		return c, errors.New("Error unmarshalling claim decisions")
+	}
+	return c, nil
+}
+
+// toRow converts a ClaimRecord to a ClaimTable row
+func (c ClaimRecord) toRow() (Row, error) {
+	decisions := c.Decisions
+	if decisions == nil {
+		decisions = []ClaimDecision{}
+	}
+	decisionsAsBytes, err := json.Marshal(decisions)
+	if err != nil {
+		return Row{}, errors.New("Error marshalling claim decisions")
+	}
+	return Row{
+		Columns: []*Column{
+			&Column{Value: &Column_String_{String_: c.DocumentUid}},
+			&Column{Value: &Column_String_{String_: c.Uid}},
+			&Column{Value: &Column_String_{String_: c.Owner}},
+			&Column{Value: &Column_String_{String_: c.Issuer}},
+			&Column{Value: &Column_String_{String_: c.DocumentType}},
+			&Column{Value: &Column_String_{String_: c.Beneficiary}},
+			&Column{Value: &Column_Int64{Int64: c.Amount}},
+			&Column{Value: &Column_String_{String_: c.Statement}},
+			&Column{Value: &Column_String_{String_: c.Status}},
+			&Column{Value: &Column_Int64{Int64: c.PaidAmount}},
+			&Column{Value: &Column_Bytes{Bytes: decisionsAsBytes}},
+			&Column{Value: &Column_String_{String_: c.CreatedAt}}},
+	}, nil
+}
+
+// Init initializes the claim model/smart contract
+func (t *Claim) Init(stub Stub, function string, args []string) ([]byte, error) {
+	// Check if table already exists
+	_, err := stub.GetTable("ClaimTable")
+	if err == nil {
+		// Table already exists; do not recreate
+		return nil, nil
+	}
+
+	// Create Claim Table
+	err = stub.CreateTable("ClaimTable", []*ColumnDefinition{
+		&ColumnDefinition{Name: "DocumentUid", Type: ColumnDefinition_STRING, Key: true},
+		&ColumnDefinition{Name: "Uid", Type: ColumnDefinition_STRING, Key: true},
+		&ColumnDefinition{Name: "Owner", Type: ColumnDefinition_STRING, Key: false},
+		&ColumnDefinition{Name: "Issuer", Type: ColumnDefinition_STRING, Key: false},
+		&ColumnDefinition{Name: "DocumentType", Type: ColumnDefinition_STRING, Key: false},
+		&ColumnDefinition{Name: "Beneficiary", Type: ColumnDefinition_STRING, Key: false},
+		&ColumnDefinition{Name: "Amount", Type: ColumnDefinition_INT64, Key: false},
+		&ColumnDefinition{Name: "Statement", Type: ColumnDefinition_STRING, Key: false},
+		&ColumnDefinition{Name: "Status", Type: ColumnDefinition_STRING, Key: false},
+		&ColumnDefinition{Name: "PaidAmount", Type: ColumnDefinition_INT64, Key: false},
+		&ColumnDefinition{Name: "Decisions", Type: ColumnDefinition_BYTES, Key: false},
+		&ColumnDefinition{Name: "CreatedAt", Type: ColumnDefinition_STRING, Key: false},
+	})
+	if err != nil {
+		return nil, errors.New("Failed creating Claim Table.")
+	}
+
+	return nil, nil
+}
+
+// SubmitClaim () – the beneficiary demands payment of an amount under an issued document
+func (t *Claim) SubmitClaim(stub Stub, args []string) ([]byte, error) {
+
+	if len(args) != 6 && len(args) != 7 {
+		return nil, argCount("6 or 7")
+	}
+	owner := args[0]
+	issuer := args[1]
+	documentUid := args[2]
+	uid := args[3]
+	statement := args[5]
+	documentType := "LG"
+	if len(args) == 7 {
+		documentType = args[6]
+	}
+
+	amount, err := strconv.ParseInt(args[4], 10, 64)
+	if err != nil || amount <= 0 {
+		return nil, invalidArgument(ErrorDetails{"field": "amount"}, "Invalid amount %s. Expecting a positive integer.", args[4])
+	}
+	if statement == "" {
+		return nil, invalidArgument(ErrorDetails{"field": "statement"}, "A supporting statement is required to submit a claim.")
+	}
+	if err := check_document_operation(stub, documentType, OpClaim, "", nil); err != nil {
+		return nil, err
+	}
+
+	var document Document
+	d, err := document.get(stub, owner, issuer, documentType, documentUid)
+	if err != nil {
+		return nil, err
+	}
+
+	// Only the beneficiary named on the document can call on it
+	terms, err := parseTerms(d.DataJSON)
+	if err != nil {
+		return nil, err
+	}
+	caller, err := get_caller(stub)
+	if err != nil {
+		return nil, err
+	}
+	if terms.Beneficiary == "" || !caller.IsParty(terms.Beneficiary) {
+		return nil, forbidden(ErrorDetails{"uid": documentUid}, "Only the beneficiary of the document can submit a claim")
+	}
+	if err := check_organisation(stub, terms.Beneficiary, organisationTypes...); err != nil {
+		return nil, err
+	}
+	// The document's Permissions can further restrict who may claim
+	if err := check_permission(stub, d.Permissions, RightClaim, terms.Beneficiary); err != nil {
+		return nil, err
+	}
+
+	if err := document.checkLive(stub, d, "claimed against"); err != nil {
+		return nil, err
+	}
+	if amount > d.AvailableAmount {
+		return nil, conflict(ErrorDetails{"uid": documentUid, "availableAmount": d.AvailableAmount}, "Claimed amount %d exceeds the %d available on document %s", amount, d.AvailableAmount, documentUid)
+	}
+
+	//time
+	createdTime, err := timestamp(stub)
+	if err != nil {
+		return nil, err
+	}
+
+	// A claim submitted without a UID gets one allocated
+	if uid == "" {
+		uid = new_id(stub, "C")
+	}
+	row, err := ClaimRecord{
+		DocumentUid:  documentUid,
+		Uid:          uid,
+		Owner:        owner,
+		Issuer:       issuer,
+		DocumentType: documentType,
+		Beneficiary:  terms.Beneficiary,
+		Amount:       amount,
+		Statement:    statement,
+		Status:       ClaimStatusSubmitted,
+		CreatedAt:    createdTime,
+	}.toRow()
+	if err != nil {
+		return nil, err
+	}
+
+	// Insert a row
+	ok, err := stub.InsertRow("ClaimTable", row)
+
+	if !ok && err == nil {
+		return nil, alreadyExists(ErrorDetails{"kind": AuditKindClaim, "uid": uid}, "Claim with uid %s already exists.", uid)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	err = audit(stub, AuditKindClaim, documentUid+"/"+uid, []string{documentUid, uid}, "", ClaimStatusSubmitted)
+	if err != nil {
+		return nil, err
+	}
+
+	parties := EventParties{Owner: owner, Issuer: issuer, Beneficiary: terms.Beneficiary}
+	if err := emit_event(stub, EventClaimSubmitted, uid, parties, "", ClaimStatusSubmitted); err != nil {
+		return nil, err
+	}
+	return json.Marshal(CreatedResponse{Uid: uid})
+}
+
+// PayClaim () – the issuer pays a claim in full or in part, lowering the available amount of the document.
+// A partly paid claim can be paid again up to the claimed amount. The claim stays payable under the terms
+// it was made on when the document is amended, the amount is paid out of the version now in force.
+func (t *Claim) PayClaim(stub Stub, args []string) ([]byte, error) {
+
+	if len(args) != 3 && len(args) != 4 {
+		return nil, argCount("3 or 4")
+	}
+	documentUid := args[0]
+	uid := args[1]
+	reason := ""
+	if len(args) == 4 {
+		reason = args[3]
+	}
+
+	amount, err := strconv.ParseInt(args[2], 10, 64)
+	if err != nil || amount <= 0 {
+		return nil, invalidArgument(ErrorDetails{"field": "amount"}, "Invalid amount %s. Expecting a positive integer.", args[2])
+	}
+
+	c, err := t.get(stub, documentUid, uid)
+	if err != nil {
+		return nil, err
+	}
+
+	// Only the issuer of the document can pay
+	if err := check_party(stub, c.Issuer); err != nil {
+		return nil, err
+	}
+
+	if c.Status != ClaimStatusSubmitted && c.Status != ClaimStatusPartiallyPaid {
+		return nil, invalidTransition(ErrorDetails{"uid": uid, "status": c.Status}, "Invalid transition: claim %s is %s and can not be paid", uid, c.Status)
+	}
+	if amount > c.Amount-c.PaidAmount {
+		return nil, conflict(ErrorDetails{"uid": uid, "amount": c.Amount, "paidAmount": c.PaidAmount}, "Paid amount %d exceeds the %d still due on the claimed amount %d", amount, c.Amount-c.PaidAmount, c.Amount)
+	}
+
+	// The claim was made on this version of the document, it may have been amended since
+	var document Document
+	d, err := document.get(stub, c.Owner, c.Issuer, c.DocumentType, documentUid)
+	if err != nil {
+		return nil, err
+	}
+	d, err = document.current(stub, d)
+	if err != nil {
+		return nil, err
+	}
+	// A claim presented before the expiry date stays payable after it
+	if d.Status != DocStatusIssued && d.Status != DocStatusExpired {
+		return nil, invalidTransition(ErrorDetails{"uid": d.Uid, "status": d.Status}, "Invalid transition: document %s is %s and can not be paid out", d.Uid, d.Status)
+	}
+	err = document.debit(stub, d, amount)
+	if err != nil {
+		return nil, err
+	}
+
+	status := ClaimStatusPaid
+	if c.PaidAmount+amount < c.Amount {
+		status = ClaimStatusPartiallyPaid
+	}
+
+	return nil, t.decide(stub, c, status, amount, reason)
+}
+
+// RejectClaim () – the issuer refuses to pay a claim, giving a reason
+func (t *Claim) RejectClaim(stub Stub, args []string) ([]byte, error) {
+
+	if len(args) != 3 {
+		return nil, argCount("3")
+	}
+	documentUid := args[0]
+	uid := args[1]
+	reason := args[2]
+
+	if reason == "" {
+		return nil, invalidArgument(ErrorDetails{"field": "reason"}, "A reason is required to reject a claim.")
+	}
+
+	c, err := t.get(stub, documentUid, uid)
+	if err != nil {
+		return nil, err
+	}
+
+	// Only the issuer of the document can reject
+	if err := check_party(stub, c.Issuer); err != nil {
+		return nil, err
+	}
+
+	if c.Status != ClaimStatusSubmitted {
+		return nil, invalidTransition(ErrorDetails{"uid": uid, "status": c.Status}, "Invalid transition: claim %s is %s and can not be rejected", uid, c.Status)
+	}
+
+	return nil, t.decide(stub, c, ClaimStatusRejected, 0, reason)
+}
+
+// GetClaims () – returns as JSON every claim made against a document
+func (t *Claim) GetClaims(stub Stub, args []string) ([]byte, error) {
+
+	if len(args) != 1 && len(args) != 2 {
+		return nil, argCount("1 or 2")
+	}
+	documentUid := args[0]
+	o, err := listOptionsArg(args, 1)
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := stub.GetRows("ClaimTable", keyColumns(documentUid))
+	if err != nil {
+		return nil, fmt.Errorf("Failed to retrieve rows")
+	}
+
+	claims := []ClaimRecord{}
+	for row := range rows {
+		if len(row.Columns) == 0 {
+			continue
+		}
+		c, err := claimFromRow(row)
+		if err != nil {
+			return nil, err
+		}
+		// Only the parties of the document can read its claims
+		if err := check_party(stub, c.Owner, c.Issuer, c.Beneficiary); err != nil {
+			return nil, err
+		}
+		if o.match(c.Status, c.CreatedAt, c.DocumentType) {
+			claims = append(claims, c)
+		}
+	}
+
+	sort.Slice(claims, func(i, j int) bool {
+		return claims[i].Uid < claims[j].Uid
+	})
+
+	keys := make([]listKey, len(claims))
+	for i, c := range claims {
+		keys[i] = listKey{c.Uid}
+	}
+	first, last, next, err := paginate(keys, o, false)
+	if err != nil {
+		return nil, err
+	}
+
+	page := claims[first:last]
+	return json.Marshal(ListResponse{Count: len(page), Data: page, NextCursor: next})
+}
+
+// get returns a claim, or an error if it does not exist
+func (t *Claim) get(stub Stub, documentUid string, uid string) (ClaimRecord, error) {
+
+	row, err := stub.GetRow("ClaimTable", keyColumns(documentUid, uid))
+	if err != nil {
+		return ClaimRecord{}, fmt.Errorf("Error: Failed retrieving claim with uid %s. Error %s", uid, err.Error())
+	}
+
+	// GetRows returns empty message if key does not exist
+	if len(row.Columns) == 0 {
+		return ClaimRecord{}, notFound(ErrorDetails{"kind": AuditKindClaim, "uid": uid}, "Claim with uid %s does not exist.", uid)
+	}
+
+	return claimFromRow(row)
+}
+
+// decide records a decision of the issuer on a claim and moves it to its new status
+func (t *Claim) decide(stub Stub, c ClaimRecord, status string, amount int64, reason string) error {
+
+	caller, err := get_caller(stub)
+	if err != nil {
+		return err
+	}
+
+	decidedAt, err := timestamp(stub)
+	if err != nil {
+		return err
+	}
+	c.Decisions = append(c.Decisions, ClaimDecision{
+		Decision:  status,
+		Amount:    amount,
+		Reason:    reason,
+		DecidedBy: caller.UserId,
+		DecidedAt: decidedAt,
+	})
+	oldStatus := c.Status
+	c.Status = status
+	c.PaidAmount += amount
+
+	row, err := c.toRow()
+	if err != nil {
+		return err
+	}
+
+	ok, err := stub.ReplaceRow("ClaimTable", row)
+
+	if !ok && err == nil {
+		return notFound(ErrorDetails{"kind": AuditKindClaim, "uid": c.Uid}, "Claim with uid %s does not exist.", c.Uid)
+	}
+	if err != nil {
+		return err
+	}
+
+	err = audit(stub, AuditKindClaim, c.DocumentUid+"/"+c.Uid, []string{c.DocumentUid, c.Uid}, oldStatus, status)
+	if err != nil {
+		return err
+	}
+
+	parties := EventParties{Owner: c.Owner, Issuer: c.Issuer, Beneficiary: c.Beneficiary}
+	return emit_event(stub, claimEvent(status), c.Uid, parties, oldStatus, status)
+}
@@ -0,0 +1,164 @@
+package main
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func getClaims(h *testChaincode, documentUid string, options ...string) []ClaimRecord {
+	h.t.Helper()
+	var page listOf
+	h.auditor().mustQueryInto(&page, "get_claims", append([]string{documentUid}, options...)...)
+	var claims []ClaimRecord
+	if err := json.Unmarshal(page.Data, &claims); err != nil {
+		h.t.Fatal(err)
+	}
+	return claims
+}
+
+func TestSubmitClaim(t *testing.T) {
+	h := newTestChaincode(t)
+	h.issueLG("R1", 1000)
+
+	h.beneficiary().mustInvoke("submit_claim", "CorpB", "BankA", "R1", "C1", "400", "Unpaid invoice 17")
+
+	claims := getClaims(h, "R1")
+	if len(claims) != 1 || claims[0].Status != ClaimStatusSubmitted || claims[0].Beneficiary != "SupplierC" {
+		t.Fatalf("unexpected claims %+v", claims)
+	}
+
+	_, err := h.beneficiary().invoke("submit_claim", "CorpB", "BankA", "R1", "C1", "100", "Again")
+	expectCode(t, err, CodeAlreadyExists)
+	_, err = h.invoke("submit_claim", "CorpB", "BankA", "R1", "C2", "1001", "Too much")
+	expectCode(t, err, CodeConflict)
+	_, err = h.invoke("submit_claim", "CorpB", "BankA", "R1", "C2", "-5", "Negative")
+	expectCode(t, err, CodeInvalidArgument)
+	_, err = h.invoke("submit_claim", "CorpB", "BankA", "R1", "C2", "5", "")
+	expectCode(t, err, CodeInvalidArgument)
+	_, err = h.as("eve", RoleBeneficiary, "SupplierE").invoke("submit_claim", "CorpB", "BankA", "R1", "C2", "5", "Not mine")
+	expectCode(t, err, CodeForbidden)
+	_, err = h.beneficiary().invoke("submit_claim", "CorpB", "BankA", "R9", "C2", "5", "No document")
+	expectCode(t, err, CodeNotFound)
+
+	// A claim submitted without a uid is given one
+	var created CreatedResponse
+	if err := json.Unmarshal(h.beneficiary().mustInvoke("submit_claim", "CorpB", "BankA", "R1", "", "5", "Unpaid invoice 18"), &created); err != nil {
+		t.Fatal(err)
+	}
+	if claims := getClaims(h, "R1"); len(claims) != 2 || claims[1].Uid != created.Uid || !strings.HasPrefix(created.Uid, "Ctx") {
+		t.Fatalf("unexpected uid %q in %+v", created.Uid, claims)
+	}
+}
+
+func TestPayClaim(t *testing.T) {
+	h := newTestChaincode(t)
+	h.issueLG("R1", 1000)
+	h.beneficiary().mustInvoke("submit_claim", "CorpB", "BankA", "R1", "C1", "400", "Unpaid invoice 17")
+	h.beneficiary().mustInvoke("submit_claim", "CorpB", "BankA", "R1", "C2", "300", "Unpaid invoice 18")
+
+	_, err := h.officer().invoke("pay_claim", "R1", "C1", "401")
+	expectCode(t, err, CodeConflict)
+
+	h.mustInvoke("pay_claim", "R1", "C1", "400")
+	h.mustInvoke("pay_claim", "R1", "C2", "100", "Only partly documented")
+
+	claims := getClaims(h, "R1")
+	if claims[0].Status != ClaimStatusPaid || claims[0].PaidAmount != 400 {
+		t.Fatalf("unexpected claim %+v", claims[0])
+	}
+	if claims[1].Status != ClaimStatusPartiallyPaid || claims[1].Decisions[0].Reason != "Only partly documented" {
+		t.Fatalf("unexpected claim %+v", claims[1])
+	}
+
+	var d DocumentRecord
+	h.officer().mustQueryInto(&d, "get_lg_by_uid", "R1")
+	if d.AvailableAmount != 500 {
+		t.Fatalf("expected 500 to remain available, got %d", d.AvailableAmount)
+	}
+
+	_, err = h.invoke("pay_claim", "R1", "C1", "1")
+	expectCode(t, err, CodeInvalidTransition)
+	_, err = h.as("eve", RoleBankOfficer, "BankE").invoke("pay_claim", "R1", "C2", "1")
+	expectCode(t, err, CodeForbidden)
+
+	// A partly paid claim is paid again, up to the claimed amount
+	_, err = h.officer().invoke("pay_claim", "R1", "C2", "201")
+	expectCode(t, err, CodeConflict)
+	h.mustInvoke("pay_claim", "R1", "C2", "150")
+	h.mustInvoke("pay_claim", "R1", "C2", "50")
+	if claims := getClaims(h, "R1"); claims[1].Status != ClaimStatusPaid || claims[1].PaidAmount != 300 || len(claims[1].Decisions) != 3 {
+		t.Fatalf("unexpected claim %+v", claims[1])
+	}
+	_, err = h.officer().invoke("pay_claim", "R1", "C2", "1")
+	expectCode(t, err, CodeInvalidTransition)
+}
+
+func TestPayClaimAfterAmendment(t *testing.T) {
+	h := newTestChaincode(t)
+	h.issueLG("R1", 1000)
+	h.beneficiary().mustInvoke("submit_claim", "CorpB", "BankA", "R1", "C1", "400", "Unpaid invoice 17")
+
+	amendment := `{"previousUid": "R1", "documentUid": "R1-A", "amount": 1500}`
+	h.applicant().mustInvoke("amend_lg_document", "CorpB", "BankA", "A1", amendment, "{}")
+	h.officer().mustInvoke("approve_new_request", "CorpB", "BankA", "A1", RequestTypeAmendment)
+
+	// The claim made on R1 is paid out of the version now in force
+	h.mustInvoke("pay_claim", "R1", "C1", "400")
+	if claims := getClaims(h, "R1"); claims[0].Status != ClaimStatusPaid {
+		t.Fatalf("unexpected claim %+v", claims[0])
+	}
+	var d DocumentRecord
+	h.mustQueryInto(&d, "get_lg_by_uid", "R1-A")
+	if d.AvailableAmount != 1100 {
+		t.Fatalf("expected 1100 to remain available on R1-A, got %d", d.AvailableAmount)
+	}
+	h.mustQueryInto(&d, "get_lg_by_uid", "R1")
+	if d.AvailableAmount != 1000 {
+		t.Fatalf("expected the superseded version to be left alone, got %d", d.AvailableAmount)
+	}
+}
+
+func TestRejectClaim(t *testing.T) {
+	h := newTestChaincode(t)
+	h.issueLG("R1", 1000)
+	h.beneficiary().mustInvoke("submit_claim", "CorpB", "BankA", "R1", "C1", "400", "Unpaid invoice 17")
+
+	_, err := h.officer().invoke("reject_claim", "R1", "C1", "")
+	expectCode(t, err, CodeInvalidArgument)
+
+	h.mustInvoke("reject_claim", "R1", "C1", "Invoice was paid")
+	if claims := getClaims(h, "R1"); claims[0].Status != ClaimStatusRejected {
+		t.Fatalf("expected rejected, got %s", claims[0].Status)
+	}
+	if last := h.stub.Events[len(h.stub.Events)-1]; last.Name != EventClaimRejected {
+		t.Fatalf("expected %s, got %s", EventClaimRejected, last.Name)
+	}
+
+	_, err = h.officer().invoke("reject_claim", "R1", "C1", "Twice")
+	expectCode(t, err, CodeInvalidTransition)
+	_, err = h.invoke("reject_claim", "R1", "C9", "Unknown")
+	expectCode(t, err, CodeNotFound)
+}
+
+func TestGetClaims(t *testing.T) {
+	h := newTestChaincode(t)
+	h.issueLG("R1", 1000)
+	h.beneficiary().mustInvoke("submit_claim", "CorpB", "BankA", "R1", "C2", "100", "Unpaid invoice 18")
+	h.beneficiary().mustInvoke("submit_claim", "CorpB", "BankA", "R1", "C1", "100", "Unpaid invoice 17")
+	h.officer().mustInvoke("reject_claim", "R1", "C2", "Invoice was paid")
+
+	claims := getClaims(h, "R1", `{"status": "submitted"}`)
+	if len(claims) != 1 || claims[0].Uid != "C1" {
+		t.Fatalf("unexpected claims %+v", claims)
+	}
+
+	var page listOf
+	h.beneficiary().mustQueryInto(&page, "get_claims", "R1", `{"pageSize": 1}`)
+	if page.Count != 1 || page.NextCursor == "" {
+		t.Fatalf("unexpected page %+v", page)
+	}
+
+	_, err := h.as("eve", RoleApplicant, "CorpE").query("get_claims", "R1")
+	expectCode(t, err, CodeForbidden)
+}
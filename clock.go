@@ -0,0 +1,47 @@
+package main
+
+import (
+	"time"
+)
+
+//==============================================================================================================================
+//	 Clock - every time dependent rule (CreatedAt, expiry, ...) reads the time through clock. Endorsing peers
+//			 must agree on the result, so the chaincode never calls time.Now(): the default clock returns the
+//			 timestamp the client put on the transaction. Tests replace clock with a FixedClock.
+//==============================================================================================================================
+
+// Clock tells the time of the transaction being executed
+type Clock interface {
+	Now(stub Stub) (time.Time, error)
+}
+
+var clock Clock = TxClock{}
+
+// TxClock reads the transaction timestamp from the stub
+type TxClock struct{}
+
+func (c TxClock) Now(stub Stub) (time.Time, error) {
+	return stub.TxTime()
+}
+
+// FixedClock always returns the same time. Advance moves it forward to simulate time passing.
+type FixedClock struct {
+	Time time.Time
+}
+
+func (c *FixedClock) Now(stub Stub) (time.Time, error) {
+	return c.Time, nil
+}
+
+func (c *FixedClock) Advance(d time.Duration) {
+	c.Time = c.Time.Add(d)
+}
+
+// timestamp returns the transaction time formatted for the CreatedAt columns
+func timestamp(stub Stub) (string, error) {
+	now, err := clock.Now(stub)
+	if err != nil {
+		return "", err
+	}
+	return now.Format(time.RFC3339), nil
+}
@@ -0,0 +1,503 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"sort"
+	"strconv"
+)
+
+//==============================================================================================================================
+//	 Function registry - Every function callable through Invoke and Query is declared here with the roles allowed
+//						 to call it and its arguments. The dispatcher checks the role, the argument count and the
+//						 argument types before the handler runs. list_functions returns the registry.
+//
+//	Arguments are passed either positionally, as declared, or as a JSON object naming them after the
+//	reserved first argument "@named":
+//		"@named", {"apiVersion": "1", "requester": "CorpB", "approver": "BankA", "uid": "R1"}
+//	No positional first argument can be "@named". Missing optional fields take their default and unknown fields are rejected. Fields of type json and
+//	options hold the JSON value itself rather than a string, integers may be given as numbers.
+//==============================================================================================================================
+
+// Kinds of functions
+const (
+	KindInvoke = "invoke"
+	KindQuery  = "query"
+)
+
+// Argument types
+const (
+	ArgString  = "string"
+	ArgJSON    = "json"    //Any JSON value
+	ArgInteger = "integer" //Base 10, fits an int64
+	ArgDate    = "date"    //YYYY-MM-DD
+	ArgOptions = "options" //List options, see list.go
+)
+
+// apiVersion is the version of the named argument form clients must send
+const apiVersion = "1"
+
+// namedArgsMarker is the first argument of a call passing its arguments by name
+const namedArgsMarker = "@named"
+
+// ArgSpec declares one positional argument. Optional arguments come last.
+type ArgSpec struct {
+	Name     string `json:"name"`
+	Type     string `json:"type"`
+	Optional bool   `json:"optional,omitempty"`
+	Default  string `json:"default,omitempty"` //Value of a missing optional argument in the named form
+}
+
+// FunctionSpec declares a function of the chaincode
+type FunctionSpec struct {
+	Name        string    `json:"name"`
+	Kind        string    `json:"kind"`
+	Description string    `json:"description"`
+	Roles       []string  `json:"roles"`
+	Args        []ArgSpec `json:"args"`
+
+	handler func(t *SimpleChaincode, stub Stub, args []string) ([]byte, error)
+}
+
+func arg(name string, argType string) ArgSpec {
+	return ArgSpec{Name: name, Type: argType}
+}
+
+func optional(name string, argType string) ArgSpec {
+	return ArgSpec{Name: name, Type: argType, Optional: true}
+}
+
+func optionalDefault(name string, argType string, value string) ArgSpec {
+	return ArgSpec{Name: name, Type: argType, Optional: true, Default: value}
+}
+
+var (
+	anyRole       = []string{RoleApplicant, RoleBankOfficer, RoleBeneficiary, RoleAuditor, RoleAdmin}
+	requestRoles  = []string{RoleApplicant, RoleBankOfficer, RoleAuditor, RoleAdmin}
+	documentRoles = []string{RoleApplicant, RoleBankOfficer, RoleBeneficiary, RoleAuditor, RoleAdmin}
+)
+
+// functions is the registry, in the order list_functions returns it. It is filled by init, the
+// list_functions handler refers to it.
+var functions []FunctionSpec
+
+func init() {
+	functions = []FunctionSpec{
+		// Administration
+		{Name: "init", Kind: KindInvoke, Description: "Creates the tables that do not exist yet",
+			Roles: []string{RoleAdmin}, Args: []ArgSpec{},
+			handler: func(t *SimpleChaincode, stub Stub, args []string) ([]byte, error) {
+				return t.init(stub, "init", args)
+			}},
+		{Name: "reset_indexes", Kind: KindInvoke, Description: "Empties the state indexes",
+			Roles: []string{RoleAdmin}, Args: []ArgSpec{},
+			handler: (*SimpleChaincode).reset_indexes},
+		{Name: "rebuild_indexes", Kind: KindInvoke, Description: "Indexes the records written before their indexes existed",
+			Roles: []string{RoleAdmin}, Args: []ArgSpec{},
+			handler: (*SimpleChaincode).rebuild_indexes},
+		{Name: "register_document_type", Kind: KindInvoke, Description: "Adds or replaces a document type",
+			Roles: []string{RoleAdmin}, Args: []ArgSpec{arg("definition", ArgJSON)},
+			handler: func(t *SimpleChaincode, stub Stub, args []string) ([]byte, error) {
+				return t.documentTypes.RegisterDocumentType(stub, args)
+			}},
+		{Name: "register_schema", Kind: KindInvoke, Description: "Sets the JSON schema of a document type",
+			Roles: []string{RoleAdmin}, Args: []ArgSpec{arg("documentType", ArgString), arg("schema", ArgJSON)},
+			handler: func(t *SimpleChaincode, stub Stub, args []string) ([]byte, error) {
+				return t.documentTypes.RegisterSchema(stub, args)
+			}},
+		{Name: "register_organisation", Kind: KindInvoke, Description: "Registers an organisation that can be named as a party",
+			Roles: []string{RoleAdmin}, Args: []ArgSpec{arg("organisation", ArgJSON)},
+			handler: func(t *SimpleChaincode, stub Stub, args []string) ([]byte, error) {
+				return t.organisations.RegisterOrganisation(stub, args)
+			}},
+		{Name: "update_organisation", Kind: KindInvoke, Description: "Changes the fields of an organisation, including its status",
+			Roles: []string{RoleAdmin}, Args: []ArgSpec{arg("id", ArgString), arg("update", ArgJSON)},
+			handler: func(t *SimpleChaincode, stub Stub, args []string) ([]byte, error) {
+				return t.organisations.UpdateOrganisation(stub, args)
+			}},
+		{Name: "add_user", Kind: KindInvoke, Description: "Registers a user",
+			Roles: []string{RoleAdmin}, Args: []ArgSpec{arg("userId", ArgString), arg("user", ArgJSON)},
+			handler: (*SimpleChaincode).add_user},
+		{Name: "update_user", Kind: KindInvoke, Description: "Changes the profile of a user. Users can only update their own record.",
+			Roles: anyRole, Args: []ArgSpec{arg("userId", ArgString), arg("profile", ArgJSON)},
+			handler: (*SimpleChaincode).update_user},
+		{Name: "deactivate_user", Kind: KindInvoke, Description: "Stops a user from authenticating and invoking functions",
+			Roles: []string{RoleAdmin}, Args: []ArgSpec{arg("userId", ArgString)},
+			handler: (*SimpleChaincode).deactivate_user},
+		{Name: "reactivate_user", Kind: KindInvoke, Description: "Reactivates a deactivated user",
+			Roles: []string{RoleAdmin}, Args: []ArgSpec{arg("userId", ArgString)},
+			handler: (*SimpleChaincode).reactivate_user},
+		{Name: "change_password", Kind: KindInvoke, Description: "Replaces the password of a user with a new salt. The current and new passwords are passed in the transient data as currentPassword and newPassword. Users can only change their own password.",
+			Roles: anyRole, Args: []ArgSpec{arg("userId", ArgString), arg("salt", ArgString)},
+			handler: (*SimpleChaincode).change_password},
+
+		// Requests
+		{Name: "submit_new_request", Kind: KindInvoke, Description: "Submits a request for a new document, or saves it as a draft",
+			Roles: []string{RoleApplicant},
+			Args: []ArgSpec{arg("requestType", ArgString), arg("requester", ArgString), arg("approver", ArgString), arg("uid", ArgString),
+				arg("docJSON", ArgJSON), arg("status", ArgString), arg("permissions", ArgJSON)},
+			handler: func(t *SimpleChaincode, stub Stub, args []string) ([]byte, error) {
+				return t.request.SubmitNewRequest(stub, args)
+			}},
+		{Name: "approve_new_request", Kind: KindInvoke, Description: "Approves a request and issues or amends its document",
+			Roles: []string{RoleBankOfficer},
+			Args:  []ArgSpec{arg("requester", ArgString), arg("approver", ArgString), arg("uid", ArgString), optionalDefault("requestType", ArgString, RequestTypeNew)},
+			handler: func(t *SimpleChaincode, stub Stub, args []string) ([]byte, error) {
+				return t.request.ApproveRequest(stub, args)
+			}},
+		{Name: "reject_request", Kind: KindInvoke, Description: "Rejects a request",
+			Roles: []string{RoleBankOfficer},
+			Args:  []ArgSpec{arg("requester", ArgString), arg("approver", ArgString), arg("uid", ArgString), arg("reason", ArgString), optionalDefault("requestType", ArgString, RequestTypeNew)},
+			handler: func(t *SimpleChaincode, stub Stub, args []string) ([]byte, error) {
+				return t.request.RejectRequest(stub, args)
+			}},
+		{Name: "return_request", Kind: KindInvoke, Description: "Returns a request to the requester for changes",
+			Roles: []string{RoleBankOfficer},
+			Args:  []ArgSpec{arg("requester", ArgString), arg("approver", ArgString), arg("uid", ArgString), arg("reason", ArgString), optionalDefault("requestType", ArgString, RequestTypeNew)},
+			handler: func(t *SimpleChaincode, stub Stub, args []string) ([]byte, error) {
+				return t.request.ReturnRequest(stub, args)
+			}},
+		{Name: "review_request", Kind: KindInvoke, Description: "Marks a request as under review",
+			Roles: []string{RoleBankOfficer},
+			Args:  []ArgSpec{arg("requester", ArgString), arg("approver", ArgString), arg("uid", ArgString), optionalDefault("requestType", ArgString, RequestTypeNew)},
+			handler: func(t *SimpleChaincode, stub Stub, args []string) ([]byte, error) {
+				return t.request.ReviewRequest(stub, args)
+			}},
+		{Name: "withdraw_request", Kind: KindInvoke, Description: "Withdraws a request",
+			Roles: []string{RoleApplicant},
+			Args:  []ArgSpec{arg("requester", ArgString), arg("approver", ArgString), arg("uid", ArgString), optionalDefault("requestType", ArgString, RequestTypeNew)},
+			handler: func(t *SimpleChaincode, stub Stub, args []string) ([]byte, error) {
+				return t.request.WithdrawRequest(stub, args)
+			}},
+		{Name: "submit_request", Kind: KindInvoke, Description: "Submits a draft or returned request, optionally replacing its DocJSON",
+			Roles: []string{RoleApplicant},
+			Args:  []ArgSpec{arg("requester", ArgString), arg("approver", ArgString), arg("uid", ArgString), optional("docJSON", ArgJSON), optionalDefault("requestType", ArgString, RequestTypeNew)},
+			handler: func(t *SimpleChaincode, stub Stub, args []string) ([]byte, error) {
+				return t.request.SubmitRequest(stub, args)
+			}},
+		{Name: "amend_lg_document", Kind: KindInvoke, Description: "Requests an amendment of an issued document",
+			Roles: []string{RoleApplicant},
+			Args:  []ArgSpec{arg("requester", ArgString), arg("approver", ArgString), arg("uid", ArgString), arg("docJSON", ArgJSON), arg("permissions", ArgJSON)},
+			handler: func(t *SimpleChaincode, stub Stub, args []string) ([]byte, error) {
+				return t.request.SubmitAmendment(stub, args)
+			}},
+
+		// Documents
+		{Name: "issue_document", Kind: KindInvoke, Description: "Issues a document directly",
+			Roles: []string{RoleBankOfficer},
+			Args: []ArgSpec{arg("owner", ArgString), arg("issuer", ArgString), arg("documentType", ArgString), arg("uid", ArgString),
+				arg("dataJSON", ArgJSON), arg("status", ArgString), arg("permissions", ArgJSON), arg("expiryDate", ArgDate)},
+			handler: func(t *SimpleChaincode, stub Stub, args []string) ([]byte, error) {
+				return t.document.IssueDocument(stub, args)
+			}},
+		{Name: "cancel_lg_document", Kind: KindInvoke, Description: "Cancels an LG",
+			Roles: []string{RoleBankOfficer},
+			Args:  []ArgSpec{arg("owner", ArgString), arg("issuer", ArgString), arg("uid", ArgString)},
+			handler: func(t *SimpleChaincode, stub Stub, args []string) ([]byte, error) {
+				return t.document.CancelLGDocument(stub, args)
+			}},
+		{Name: "cancel_document", Kind: KindInvoke, Description: "Cancels a document of any type",
+			Roles: []string{RoleBankOfficer},
+			Args:  []ArgSpec{arg("owner", ArgString), arg("issuer", ArgString), arg("documentType", ArgString), arg("uid", ArgString)},
+			handler: func(t *SimpleChaincode, stub Stub, args []string) ([]byte, error) {
+				return t.document.CancelDocument(stub, args)
+			}},
+		{Name: "expire_documents", Kind: KindInvoke, Description: "Expires a page of the documents past their expiry date, oldest first",
+			Roles: []string{RoleBankOfficer, RoleAdmin}, Args: []ArgSpec{optional("options", ArgOptions)},
+			handler: func(t *SimpleChaincode, stub Stub, args []string) ([]byte, error) {
+				return t.document.ExpireDocuments(stub, args)
+			}},
+
+		// Claims
+		{Name: "submit_claim", Kind: KindInvoke, Description: "The beneficiary claims against a document",
+			Roles: []string{RoleBeneficiary},
+			Args: []ArgSpec{arg("owner", ArgString), arg("issuer", ArgString), arg("documentUid", ArgString), arg("uid", ArgString),
+				arg("amount", ArgInteger), arg("statement", ArgString), optionalDefault("documentType", ArgString, "LG")},
+			handler: func(t *SimpleChaincode, stub Stub, args []string) ([]byte, error) {
+				return t.claim.SubmitClaim(stub, args)
+			}},
+		{Name: "pay_claim", Kind: KindInvoke, Description: "The issuer pays a claim in full or in part",
+			Roles: []string{RoleBankOfficer},
+			Args:  []ArgSpec{arg("documentUid", ArgString), arg("uid", ArgString), arg("amount", ArgInteger), optional("reason", ArgString)},
+			handler: func(t *SimpleChaincode, stub Stub, args []string) ([]byte, error) {
+				return t.claim.PayClaim(stub, args)
+			}},
+		{Name: "reject_claim", Kind: KindInvoke, Description: "The issuer rejects a claim",
+			Roles: []string{RoleBankOfficer},
+			Args:  []ArgSpec{arg("documentUid", ArgString), arg("uid", ArgString), arg("reason", ArgString)},
+			handler: func(t *SimpleChaincode, stub Stub, args []string) ([]byte, error) {
+				return t.claim.RejectClaim(stub, args)
+			}},
+
+		// Queries
+		{Name: "get_user", Kind: KindQuery, Description: "Returns a user without its credentials. Users can only read their own record.",
+			Roles: anyRole, Args: []ArgSpec{arg("index", ArgString), arg("userId", ArgString)},
+			handler: func(t *SimpleChaincode, stub Stub, args []string) ([]byte, error) {
+				c, err := get_caller(stub)
+				if err != nil {
+					return nil, err
+				}
+				if !c.HasRole(RoleAuditor, RoleAdmin) && c.UserId != args[1] {
+					return nil, forbidden(ErrorDetails{"userId": args[1]}, "Users can only read their own record")
+				}
+				return t.get_user(stub, args[1])
+			}},
+		{Name: "authenticate", Kind: KindQuery, Description: "Checks the password of a user",
+			Roles: anyRole, Args: []ArgSpec{arg("userId", ArgString), arg("password", ArgString)},
+			handler: (*SimpleChaincode).authenticate},
+		{Name: "list_users", Kind: KindQuery, Description: "Lists the users, filtered by status, organisation and role",
+			Roles: []string{RoleAdmin, RoleAuditor}, Args: []ArgSpec{optional("options", ArgOptions)},
+			handler: (*SimpleChaincode).list_users},
+		{Name: "get_organisation", Kind: KindQuery, Description: "Returns an organisation",
+			Roles: anyRole, Args: []ArgSpec{arg("id", ArgString)},
+			handler: func(t *SimpleChaincode, stub Stub, args []string) ([]byte, error) {
+				return t.organisations.GetOrganisation(stub, args)
+			}},
+		{Name: "list_organisations", Kind: KindQuery, Description: "Lists the organisations, filtered by status and type",
+			Roles: anyRole, Args: []ArgSpec{optional("options", ArgOptions)},
+			handler: func(t *SimpleChaincode, stub Stub, args []string) ([]byte, error) {
+				return t.organisations.GetOrganisations(stub, args)
+			}},
+		{Name: "get_request_json", Kind: KindQuery, Description: "Returns a request",
+			Roles: requestRoles,
+			Args:  []ArgSpec{arg("requester", ArgString), arg("approver", ArgString), arg("uid", ArgString), optionalDefault("requestType", ArgString, RequestTypeNew)},
+			handler: func(t *SimpleChaincode, stub Stub, args []string) ([]byte, error) {
+				return t.request.GetJSON(stub, args)
+			}},
+		{Name: "get_request_by_uid", Kind: KindQuery, Description: "Returns a request found by its uid alone",
+			Roles: requestRoles, Args: []ArgSpec{arg("uid", ArgString)},
+			handler: func(t *SimpleChaincode, stub Stub, args []string) ([]byte, error) {
+				return t.request.GetRequestByUid(stub, args)
+			}},
+		{Name: "get_new_requests", Kind: KindQuery, Description: "Lists the new requests of a requester",
+			Roles: []string{RoleApplicant, RoleAuditor, RoleAdmin},
+			Args:  []ArgSpec{arg("requester", ArgString), optional("options", ArgOptions)},
+			handler: func(t *SimpleChaincode, stub Stub, args []string) ([]byte, error) {
+				return t.request.GetNewRequests(stub, args)
+			}},
+		{Name: "get_requests_for_approver", Kind: KindQuery, Description: "Lists the requests waiting for an approver, newest first",
+			Roles: []string{RoleBankOfficer, RoleAuditor, RoleAdmin},
+			Args:  []ArgSpec{arg("approver", ArgString), optional("status", ArgString), optional("requestType", ArgString), optional("options", ArgOptions)},
+			handler: func(t *SimpleChaincode, stub Stub, args []string) ([]byte, error) {
+				return t.request.GetRequestsForApprover(stub, args)
+			}},
+		{Name: "get_lg_document_json", Kind: KindQuery, Description: "Returns an LG",
+			Roles: documentRoles, Args: []ArgSpec{arg("owner", ArgString), arg("issuer", ArgString), arg("uid", ArgString)},
+			handler: func(t *SimpleChaincode, stub Stub, args []string) ([]byte, error) {
+				return t.document.GetLgJSON(stub, args)
+			}},
+		{Name: "get_document", Kind: KindQuery, Description: "Returns a document of any type",
+			Roles: documentRoles,
+			Args:  []ArgSpec{arg("owner", ArgString), arg("issuer", ArgString), arg("documentType", ArgString), arg("uid", ArgString)},
+			handler: func(t *SimpleChaincode, stub Stub, args []string) ([]byte, error) {
+				return t.document.GetDocument(stub, args)
+			}},
+		{Name: "get_lg_by_uid", Kind: KindQuery, Description: "Returns a document found by its uid alone",
+			Roles: documentRoles, Args: []ArgSpec{arg("uid", ArgString)},
+			handler: func(t *SimpleChaincode, stub Stub, args []string) ([]byte, error) {
+				return t.document.GetLgByUid(stub, args)
+			}},
+		{Name: "get_lg_history", Kind: KindQuery, Description: "Returns every version of a document, oldest first",
+			Roles: documentRoles,
+			Args:  []ArgSpec{arg("owner", ArgString), arg("issuer", ArgString), arg("uid", ArgString), optionalDefault("documentType", ArgString, "LG")},
+			handler: func(t *SimpleChaincode, stub Stub, args []string) ([]byte, error) {
+				return t.document.GetLgHistory(stub, args)
+			}},
+		{Name: "get_documents", Kind: KindQuery, Description: "Lists the documents of an owner",
+			Roles: requestRoles, Args: []ArgSpec{arg("owner", ArgString), optional("issuer", ArgString), optional("options", ArgOptions)},
+			handler: func(t *SimpleChaincode, stub Stub, args []string) ([]byte, error) {
+				return t.document.GetDocuments(stub, args)
+			}},
+		{Name: "get_document_types", Kind: KindQuery, Description: "Lists the registered document types",
+			Roles: anyRole, Args: []ArgSpec{optional("options", ArgOptions)},
+			handler: func(t *SimpleChaincode, stub Stub, args []string) ([]byte, error) {
+				return t.documentTypes.GetDocumentTypes(stub, args)
+			}},
+		{Name: "get_schema", Kind: KindQuery, Description: "Returns the JSON schema of a document type",
+			Roles: anyRole, Args: []ArgSpec{arg("documentType", ArgString)},
+			handler: func(t *SimpleChaincode, stub Stub, args []string) ([]byte, error) {
+				return t.documentTypes.GetSchema(stub, args)
+			}},
+		{Name: "get_claims", Kind: KindQuery, Description: "Lists the claims against a document",
+			Roles: anyRole, Args: []ArgSpec{arg("documentUid", ArgString), optional("options", ArgOptions)},
+			handler: func(t *SimpleChaincode, stub Stub, args []string) ([]byte, error) {
+				return t.claim.GetClaims(stub, args)
+			}},
+		{Name: "get_audit_trail", Kind: KindQuery, Description: "Returns the changes made to a record, oldest first",
+			Roles: requestRoles, Args: []ArgSpec{arg("kind", ArgString), arg("uid", ArgString), optional("options", ArgOptions)},
+			handler: (*SimpleChaincode).GetAuditTrail},
+		{Name: "list_functions", Kind: KindQuery, Description: "Returns this registry",
+			Roles: anyRole, Args: []ArgSpec{},
+			handler: (*SimpleChaincode).list_functions},
+	}
+}
+
+// lookup_function returns the registered function of a kind with the given name
+func lookup_function(kind string, name string) (FunctionSpec, bool) {
+	for _, f := range functions {
+		if f.Kind == kind && f.Name == name {
+			return f, true
+		}
+	}
+	return FunctionSpec{}, false
+}
+
+// dispatch runs a registered function after checking the caller's role and the arguments
+func (t *SimpleChaincode) dispatch(stub Stub, kind string, function string, args []string) ([]byte, error) {
+
+	f, found := lookup_function(kind, function)
+	if !found {
+		return nil, invalidArgument(ErrorDetails{"function": function}, "Received unknown %s function name", kind)
+	}
+
+	if _, err := require_role(stub, f.Roles...); err != nil {
+		return nil, err
+	}
+	if kind == KindInvoke {
+		if err := check_active_user(stub); err != nil {
+			return nil, err
+		}
+	}
+	named, ok, err := namedArgs(args)
+	if err != nil {
+		return nil, err
+	}
+	if ok {
+		args, err = f.positionalArgs(named)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if err := f.checkArgs(args); err != nil {
+		return nil, err
+	}
+
+	payload, err := f.handler(t, stub, args)
+	return payload, as_chaincode_error(err)
+}
+
+// namedArgs returns the fields of the named argument form. A call uses it when its first argument is
+// namedArgsMarker, followed by a JSON object and nothing else.
+func namedArgs(args []string) (map[string]json.RawMessage, bool, error) {
+	if len(args) == 0 || args[0] != namedArgsMarker {
+		return nil, false, nil
+	}
+	if len(args) != 2 {
+		return nil, false, argCount("2")
+	}
+	var named map[string]json.RawMessage
+	if err := json.Unmarshal([]byte(args[1]), &named); err != nil || named == nil {
+		return nil, false, invalidArgument(ErrorDetails{"field": namedArgsMarker}, "Invalid named arguments: expecting a JSON object")
+	}
+	return named, true, nil
+}
+
+// positionalArgs converts named arguments to their positional form
+func (f FunctionSpec) positionalArgs(named map[string]json.RawMessage) ([]string, error) {
+
+	var version string
+	if err := json.Unmarshal(named["apiVersion"], &version); err != nil || version != apiVersion {
+		return nil, invalidArgument(ErrorDetails{"field": "apiVersion", "expecting": apiVersion}, "Unsupported apiVersion %s. Expecting \"%s\".", named["apiVersion"], apiVersion)
+	}
+
+	// Sorted so every peer reports the same unknown field
+	names := make([]string, 0, len(named))
+	for name := range named {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if name == "apiVersion" {
+			continue
+		}
+		if _, found := f.arg(name); !found {
+			return nil, invalidArgument(ErrorDetails{"field": name}, "Unknown field %s for function %s.", name, f.Name)
+		}
+	}
+
+	args := make([]string, len(f.Args))
+	for i, a := range f.Args {
+		value, found := named[a.Name]
+		if !found || string(value) == "null" {
+			if !a.Optional {
+				return nil, invalidArgument(ErrorDetails{"field": a.Name}, "Missing field %s for function %s.", a.Name, f.Name)
+			}
+			args[i] = a.Default
+			continue
+		}
+
+		switch a.Type {
+		case ArgJSON, ArgOptions:
+			// The JSON value itself
+			args[i] = string(value)
+		case ArgInteger:
+			// A number, or a string holding one
+			var n json.Number
+			if err := json.Unmarshal(value, &n); err != nil {
+				return nil, invalidArgument(ErrorDetails{"field": a.Name}, "Invalid field %s: expecting an integer", a.Name)
+			}
+			args[i] = n.String()
+		default:
+			if err := json.Unmarshal(value, &args[i]); err != nil {
+				return nil, invalidArgument(ErrorDetails{"field": a.Name}, "Invalid field %s: expecting a string", a.Name)
+			}
+		}
+	}
+	return args, nil
+}
+
+// arg returns the declared argument with the given name
+func (f FunctionSpec) arg(name string) (ArgSpec, bool) {
+	for _, a := range f.Args {
+		if a.Name == name {
+			return a, true
+		}
+	}
+	return ArgSpec{}, false
+}
+
+// checkArgs checks the number of arguments and the type of every non empty argument
+func (f FunctionSpec) checkArgs(args []string) error {
+
+	required := 0
+	for _, a := range f.Args {
+		if !a.Optional {
+			required++
+		}
+	}
+	if len(args) < required || len(args) > len(f.Args) {
+		if required == len(f.Args) {
+			return argCount(strconv.Itoa(required))
+		}
+		return argCount(strconv.Itoa(required) + " to " + strconv.Itoa(len(f.Args)))
+	}
+
+	for i, value := range args {
+		if value == "" {
+			continue
+		}
+		a := f.Args[i]
+		var err error
+		switch a.Type {
+		case ArgJSON:
+			err = checkJSON(a.Name, []byte(value))
+		case ArgInteger:
+			if _, perr := strconv.ParseInt(value, 10, 64); perr != nil {
+				err = errors.New("expecting an integer")
+			}
+		case ArgDate:
+			_, err = parseExpiryDate(value)
+		case ArgOptions:
+			_, err = parseListOptions(value)
+		}
+		if err != nil {
+			return invalidArgument(ErrorDetails{"argument": a.Name, "index": i}, "Argument %d (%s): %s", i, a.Name, error_message(err))
+		}
+	}
+	return nil
+}
+
+// list_functions returns the registry so clients can discover the API
+func (t *SimpleChaincode) list_functions(stub Stub, args []string) ([]byte, error) {
+	return json.Marshal(ListResponse{Count: len(functions), Data: functions})
+}
@@ -0,0 +1,239 @@
+package main
+
+import (
+	"encoding/json"
+	"sort"
+	"strings"
+)
+
+// DocumentTypes is the registry of the kinds of documents the chaincode handles. Each type names the
+// fields its DataJSON must carry and the operations allowed on its documents.
+type DocumentTypes struct {
+}
+
+// DocumentTypeDef is the registry entry of a document type
+type DocumentTypeDef struct {
+	Type           string   `json:"type"` //Value of the DocumentType column, e.g. "BID_BOND"
+	Name           string   `json:"name"`
+	RequiredFields []string `json:"requiredFields"` //Top level fields of DataJSON that must be present
+	Operations     []string `json:"operations"`     //Subset of the Op* constants
+}
+
+// Operations on documents that the registry can allow per type
+const (
+	OpIssue  = "issue"
+	OpAmend  = "amend"
+	OpCancel = "cancel"
+	OpClaim  = "claim"
+)
+
+var documentOperations = []string{OpIssue, OpAmend, OpCancel, OpClaim}
+
+// defaultDocumentTypes are registered on deploy. "LG" is the generic letter of guarantee every
+// deployment started with, it keeps accepting any DataJSON.
+var defaultDocumentTypes = []DocumentTypeDef{
+	{Type: "LG", Name: "Letter of guarantee", RequiredFields: []string{}, Operations: documentOperations},
+	{Type: "PERFORMANCE_BOND", Name: "Performance bond", RequiredFields: []string{"amount", "beneficiary", "contractReference"}, Operations: documentOperations},
+	{Type: "BID_BOND", Name: "Bid bond", RequiredFields: []string{"amount", "beneficiary", "tenderReference"}, Operations: []string{OpIssue, OpCancel, OpClaim}},
+	{Type: "ADVANCE_PAYMENT_GUARANTEE", Name: "Advance payment guarantee", RequiredFields: []string{"amount", "beneficiary", "contractReference"}, Operations: documentOperations},
+	{Type: "STANDBY_LC", Name: "Standby letter of credit", RequiredFields: []string{"amount", "beneficiary", "applicant"}, Operations: documentOperations},
+}
+
+// Init creates the registry and registers the default document types that are missing
+func (t *DocumentTypes) Init(stub Stub, function string, args []string) ([]byte, error) {
+
+	_, err := stub.GetTable("DocumentTypeTable")
+	if err != nil {
+		err = stub.CreateTable("DocumentTypeTable", []*ColumnDefinition{
+			&ColumnDefinition{Name: "Type", Type: ColumnDefinition_STRING, Key: true},
+			&ColumnDefinition{Name: "Definition", Type: ColumnDefinition_BYTES, Key: false},
+		})
+		if err != nil {
+			return nil, internalError(ErrorDetails{"table": "DocumentTypeTable"}, "Failed creating DocumentTypeTable.")
+		}
+	}
+
+	if err := create_schema_table(stub); err != nil {
+		return nil, err
+	}
+
+	// Types an admin has already changed are left alone
+	for _, def := range defaultDocumentTypes {
+		defAsBytes, _ := json.Marshal(def)
+		_, err := stub.InsertRow("DocumentTypeTable", documentTypeRow(def.Type, defAsBytes))
+		if err != nil {
+			return nil, err
+		}
+	}
+	return nil, nil
+}
+
+func documentTypeRow(documentType string, defAsBytes []byte) Row {
+	return Row{
+		Columns: []*Column{
+			&Column{Value: &Column_String_{String_: documentType}},
+			&Column{Value: &Column_Bytes{Bytes: defAsBytes}}},
+	}
+}
+
+// RegisterDocumentType () – adds a document type, or replaces the definition of a registered one
+func (t *DocumentTypes) RegisterDocumentType(stub Stub, args []string) ([]byte, error) {
+
+	//Args
+	//			0
+	//		definition JSON object (as string)
+	if len(args) != 1 {
+		return nil, argCount("1")
+	}
+
+	var def DocumentTypeDef
+	err := json.Unmarshal([]byte(args[0]), &def)
+	if err != nil {
+		return nil, invalidArgument(ErrorDetails{"field": "definition"}, "Invalid document type: %s", err.Error())
+	}
+	if def.Type == "" {
+		return nil, invalidArgument(ErrorDetails{"field": "type"}, "Invalid document type: type is required")
+	}
+	for _, op := range def.Operations {
+		if !contains(documentOperations, op) {
+			return nil, invalidArgument(ErrorDetails{"field": "operations", "expecting": documentOperations}, "Invalid document type: unknown operation %s. Expecting one of %s.", op, strings.Join(documentOperations, ", "))
+		}
+	}
+	if def.RequiredFields == nil {
+		def.RequiredFields = []string{}
+	}
+	if def.Operations == nil {
+		def.Operations = []string{}
+	}
+
+	defAsBytes, _ := json.Marshal(def)
+	row := documentTypeRow(def.Type, defAsBytes)
+
+	ok, err := stub.ReplaceRow("DocumentTypeTable", row)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		ok, err = stub.InsertRow("DocumentTypeTable", row)
+		if !ok && err == nil {
+			return nil, conflict(ErrorDetails{"kind": AuditKindDocumentType, "type": def.Type}, "Document type %s was registered by another transaction.", def.Type)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return nil, audit(stub, AuditKindDocumentType, def.Type, []string{def.Type}, "", "")
+}
+
+// GetDocumentTypes () – lists the registered document types
+func (t *DocumentTypes) GetDocumentTypes(stub Stub, args []string) ([]byte, error) {
+
+	if len(args) > 1 {
+		return nil, argCount("0 or 1")
+	}
+	o, err := listOptionsArg(args, 0)
+	if err != nil {
+		return nil, err
+	}
+	if o.Status != "" || o.CreatedFrom != "" || o.CreatedTo != "" {
+		return nil, invalidArgument(ErrorDetails{"field": "options"}, "Invalid list options: document types can only be filtered by documentType")
+	}
+
+	// An empty key selects every row of the table
+	rows, err := stub.GetRows("DocumentTypeTable", keyColumns())
+	if err != nil {
+		return nil, internalError(ErrorDetails{"table": "DocumentTypeTable"}, "Failed to retrieve rows")
+	}
+
+	defs := []DocumentTypeDef{}
+	for row := range rows {
+		if len(row.Columns) == 0 {
+			continue
+		}
+		var def DocumentTypeDef
+		err := json.Unmarshal(row.Columns[1].GetBytes(), &def)
+		if err != nil {
+			return nil, internalError(ErrorDetails{"kind": AuditKindDocumentType, "type": row.Columns[0].GetString_()}, "Error unmarshalling document type %s", row.Columns[0].GetString_())
+		}
+		if o.match("", "", def.Type) {
+			defs = append(defs, def)
+		}
+	}
+
+	sort.Slice(defs, func(i, j int) bool {
+		return defs[i].Type < defs[j].Type
+	})
+
+	keys := make([]listKey, len(defs))
+	for i, def := range defs {
+		keys[i] = listKey{def.Type}
+	}
+	first, last, next, err := paginate(keys, o, false)
+	if err != nil {
+		return nil, err
+	}
+
+	page := defs[first:last]
+	return json.Marshal(ListResponse{Count: len(page), Data: page, NextCursor: next})
+}
+
+// get_document_type returns the registry entry of a document type
+func get_document_type(stub Stub, documentType string) (DocumentTypeDef, error) {
+
+	var def DocumentTypeDef
+
+	row, err := stub.GetRow("DocumentTypeTable", keyColumns(documentType))
+	if err != nil {
+		return def, internalError(ErrorDetails{"kind": AuditKindDocumentType, "type": documentType}, "Error: Failed retrieving document type %s. Error %s", documentType, err.Error())
+	}
+	if len(row.Columns) == 0 {
+		return def, notFound(ErrorDetails{"kind": AuditKindDocumentType, "type": documentType}, "Unknown document type %s.", documentType)
+	}
+
+	err = json.Unmarshal(row.Columns[1].GetBytes(), &def)
+	if err != nil {
+		return def, internalError(ErrorDetails{"kind": AuditKindDocumentType, "type": documentType}, "Error unmarshalling document type %s", documentType)
+	}
+	return def, nil
+}
+
+// check_document_operation returns an error unless the registry allows the operation on documents of
+// the given type. A non empty dataJSON must also carry every field the type requires and follow its schema;
+// errors name it after the argument it came from, such as DataJSON or DocJSON.
+func check_document_operation(stub Stub, documentType string, op string, name string, dataJSON []byte) error {
+
+	def, err := get_document_type(stub, documentType)
+	if err != nil {
+		return err
+	}
+	if !contains(def.Operations, op) {
+		return forbidden(ErrorDetails{"type": documentType, "operation": op}, "Operation %s is not allowed on documents of type %s.", op, documentType)
+	}
+	if len(dataJSON) == 0 {
+		return nil
+	}
+
+	var fields map[string]json.RawMessage
+	err = json.Unmarshal(dataJSON, &fields)
+	if err != nil {
+		return invalidArgument(ErrorDetails{"field": name}, "Invalid %s: %s", name, err.Error())
+	}
+	for _, field := range def.RequiredFields {
+		value, found := fields[field]
+		if !found || string(value) == "null" {
+			return invalidArgument(ErrorDetails{"type": documentType, "field": field}, "Documents of type %s require the field %s.", documentType, field)
+		}
+	}
+	return validate_document(stub, documentType, name, dataJSON)
+}
+
+// contains returns true if values holds value
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
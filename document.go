@@ -1,20 +1,137 @@
 package main
 
 import (
-	//"encoding/json"
+	"encoding/json"
 	"errors"
 	"fmt"
-
-	"github.com/hyperledger/fabric/core/chaincode/shim"
-  "time"
+	"sort"
+	"time"
 )
 
 type Document struct {
 
 }
 
+// DocumentRecord is a row of the DocumentTable
+type DocumentRecord struct {
+	Owner           string          `json:"owner"`
+	Issuer          string          `json:"issuer"`
+	DocumentType    string          `json:"documentType"`
+	Uid             string          `json:"uid"`
+	DataJSON        json.RawMessage `json:"data"`
+	Status          string          `json:"status"`
+	Permissions     json.RawMessage `json:"permissions"`
+	ExpiryDate      string          `json:"expiryDate"`
+	PreviousUid     string          `json:"previousUid"`
+	CreatedAt       string          `json:"createdAt"`
+	RequestUid      string          `json:"requestUid"`
+	AvailableAmount int64           `json:"availableAmount"`
+}
+
+// documentFromRow converts a DocumentTable row to a DocumentRecord
+func documentFromRow(row Row) DocumentRecord {
+	return DocumentRecord{
+		Owner:           row.Columns[0].GetString_(),
+		Issuer:          row.Columns[1].GetString_(),
+		DocumentType:    row.Columns[2].GetString_(),
+		Uid:             row.Columns[3].GetString_(),
+		DataJSON:        rawJSON(row.Columns[4].GetBytes()),
+		Status:          row.Columns[5].GetString_(),
+		Permissions:     rawJSON(row.Columns[6].GetBytes()),
+		ExpiryDate:      row.Columns[7].GetString_(),
+		PreviousUid:     row.Columns[8].GetString_(),
+		CreatedAt:       row.Columns[9].GetString_(),
+		RequestUid:      row.Columns[10].GetString_(),
+		AvailableAmount: row.Columns[11].GetInt64(),
+	}
+}
+
+// toRow converts a DocumentRecord to a DocumentTable row
+func (d DocumentRecord) toRow() Row {
+	return Row{
+		Columns: []*Column{
+			&Column{Value: &Column_String_{String_: d.Owner}},
+			&Column{Value: &Column_String_{String_: d.Issuer}},
+			&Column{Value: &Column_String_{String_: d.DocumentType}},
+			&Column{Value: &Column_String_{String_: d.Uid}},
+			&Column{Value: &Column_Bytes{Bytes: d.DataJSON}},
+			&Column{Value: &Column_String_{String_: d.Status}},
+			&Column{Value: &Column_Bytes{Bytes: d.Permissions}},
+			&Column{Value: &Column_String_{String_: d.ExpiryDate}},
+			&Column{Value: &Column_String_{String_: d.PreviousUid}},
+			&Column{Value: &Column_String_{String_: d.CreatedAt}},
+			&Column{Value: &Column_String_{String_: d.RequestUid}},
+			&Column{Value: &Column_Int64{Int64: d.AvailableAmount}}},
+	}
+}
+
+// Document statuses
+const (
+	DocStatusIssued     = "issued"
+	DocStatusCancelled  = "cancelled"
+	DocStatusSuperseded = "superseded"
+	DocStatusExpired    = "expired"
+)
+
+// expiryDateLayout is the format of ExpiryDate. A document is valid up to and including its expiry date.
+const expiryDateLayout = "2006-01-02"
+
+// parseExpiryDate validates an ExpiryDate and returns the first instant the document is no longer valid
+func parseExpiryDate(expiryDate string) (time.Time, error) {
+	day, err := time.Parse(expiryDateLayout, expiryDate)
+	if err != nil {
+		return day, invalidArgument(ErrorDetails{"field": "expiryDate"}, "Invalid expiry date %s. Expecting YYYY-MM-DD.", expiryDate)
+	}
+	return day.AddDate(0, 0, 1), nil
+}
+
+// isExpired returns true if the transaction timestamp is past the expiry date. Documents issued
+// before expiry dates were validated may have none and never expire.
+func isExpired(stub Stub, expiryDate string) (bool, error) {
+	if expiryDate == "" {
+		return false, nil
+	}
+	end, err := parseExpiryDate(expiryDate)
+	if err != nil {
+		return false, err
+	}
+	now, err := clock.Now(stub)
+	if err != nil {
+		return false, err
+	}
+	return !now.Before(end), nil
+}
+
+// documentTerms holds the fields of a document's DataJSON the chaincode acts on.
+// Amounts are integers in the smallest unit of the currency, e.g. cents.
+type documentTerms struct {
+	Amount      int64  `json:"amount"`
+	Beneficiary string `json:"beneficiary"`
+}
+
+// parseTerms reads the terms of a document from its DataJSON
+func parseTerms(dataJSON []byte) (documentTerms, error) {
+	var terms documentTerms
+	err := json.Unmarshal(dataJSON, &terms)
+	if err != nil {
+		return terms, invalidArgument(ErrorDetails{"field": "dataJSON"}, "Invalid DataJSON: %s", err.Error())
+	}
+	if terms.Amount < 0 {
+		return terms, invalidArgument(ErrorDetails{"field": "dataJSON"}, "Invalid DataJSON: amount can not be negative")
+	}
+	return terms, nil
+}
+
 //Init initializes the request model/smart contract
-func (t *Document) Init(stub *shim.ChaincodeStub, function string, args []string) ([]byte, error) {
+func (t *Document) Init(stub Stub, function string, args []string) ([]byte, error) {
+	// The UID index is created on its own, deployments that predate it already have the table
+	if err := create_uid_index(stub, documentUidIndex); err != nil {
+		return nil, err
+	}
+	if err := create_expiry_index(stub); err != nil {
+		return nil, err
+	}
+
 	// Check if table already exists
 	_, err := stub.GetTable("DocumentTable")
 	if err == nil {
@@ -23,17 +140,19 @@ func (t *Document) Init(stub *shim.ChaincodeStub, function string, args []string
 	}
 
 	// Create Document Table
-	err = stub.CreateTable("DocumentTable", []*shim.ColumnDefinition{
-    &shim.ColumnDefinition{Name: "Owner", Type: shim.ColumnDefinition_STRING, Key: true},
-    &shim.ColumnDefinition{Name: "Issuer", Type: shim.ColumnDefinition_STRING, Key: true},
-    &shim.ColumnDefinition{Name: "DocumentType", Type: shim.ColumnDefinition_STRING, Key: true},
-		&shim.ColumnDefinition{Name: "Uid", Type: shim.ColumnDefinition_STRING, Key: true},
-		&shim.ColumnDefinition{Name: "DataJSON", Type: shim.ColumnDefinition_BYTES, Key: false},
-		&shim.ColumnDefinition{Name: "Status", Type: shim.ColumnDefinition_STRING, Key: false},
-    &shim.ColumnDefinition{Name: "Permissions", Type: shim.ColumnDefinition_BYTES, Key: false},
-    &shim.ColumnDefinition{Name: "ExpiryDate", Type: shim.ColumnDefinition_STRING, Key: false},
-    &shim.ColumnDefinition{Name: "PreviousUid", Type: shim.ColumnDefinition_STRING, Key: false},
-    &shim.ColumnDefinition{Name: "CreatedAt", Type: shim.ColumnDefinition_STRING, Key: false},
+	err = stub.CreateTable("DocumentTable", []*ColumnDefinition{
+    &ColumnDefinition{Name: "Owner", Type: ColumnDefinition_STRING, Key: true},
+    &ColumnDefinition{Name: "Issuer", Type: ColumnDefinition_STRING, Key: true},
+    &ColumnDefinition{Name: "DocumentType", Type: ColumnDefinition_STRING, Key: true},
+		&ColumnDefinition{Name: "Uid", Type: ColumnDefinition_STRING, Key: true},
+		&ColumnDefinition{Name: "DataJSON", Type: ColumnDefinition_BYTES, Key: false},
+		&ColumnDefinition{Name: "Status", Type: ColumnDefinition_STRING, Key: false},
+    &ColumnDefinition{Name: "Permissions", Type: ColumnDefinition_BYTES, Key: false},
+    &ColumnDefinition{Name: "ExpiryDate", Type: ColumnDefinition_STRING, Key: false},
+    &ColumnDefinition{Name: "PreviousUid", Type: ColumnDefinition_STRING, Key: false},
+    &ColumnDefinition{Name: "CreatedAt", Type: ColumnDefinition_STRING, Key: false},
+    &ColumnDefinition{Name: "RequestUid", Type: ColumnDefinition_STRING, Key: false},
+    &ColumnDefinition{Name: "AvailableAmount", Type: ColumnDefinition_INT64, Key: false},
   })
 	if err != nil {
 		return nil, errors.New("Failed creating Document Table.")
@@ -44,10 +163,10 @@ func (t *Document) Init(stub *shim.ChaincodeStub, function string, args []string
 }
 
 //Issue Document(LG) , creates a new document
-func (t *Document) IssueDocument(stub *shim.ChaincodeStub, args []string) ([]byte, error) {
+func (t *Document) IssueDocument(stub Stub, args []string) ([]byte, error) {
 
 	if len(args) != 8 {
-		return nil, errors.New("Incorrect number of arguments. Expecting 8.")
+		return nil, argCount("8")
 	}
 
 	owner :=args[0]
@@ -58,142 +177,704 @@ func (t *Document) IssueDocument(stub *shim.ChaincodeStub, args []string) ([]byt
   status := args[5]
   permissions :=[]byte(args[6])
   expiryDate :=args[7]
-  previousUid := ""
 
-	//TODO: Validate input
+	// Only the issuer can issue its own documents
+	if err := check_party(stub, issuer); err != nil {
+		return nil, err
+	}
 
-  //time
-  createdTime := time.Now()
+	// Documents always start issued, the other statuses are reached through cancel, amend and expire
+	if status == "" {
+		status = DocStatusIssued
+	}
+	if status != DocStatusIssued {
+		return nil, invalidArgument(ErrorDetails{"field": "status", "expecting": DocStatusIssued}, "Invalid status %s. Expecting %s.", status, DocStatusIssued)
+	}
 
+	if err := checkJSON("DataJSON", dataJSON); err != nil {
+		return nil, err
+	}
+	if err := checkPermissions(permissions); err != nil {
+		return nil, err
+	}
+
+	// A document issued without a UID gets one allocated
+	if uid == "" {
+		uid = new_id(stub, "D")
+	}
+	err := t.issue(stub, DocumentRecord{
+		Owner:        owner,
+		Issuer:       issuer,
+		DocumentType: documentType,
+		Uid:          uid,
+		DataJSON:     dataJSON,
+		Status:       status,
+		Permissions:  permissions,
+		ExpiryDate:   expiryDate,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(CreatedResponse{Uid: uid})
+}
+
+// issue adds the first version of a document, whose full amount is available to claims
+func (t *Document) issue(stub Stub, d DocumentRecord) error {
+
+	// The issuer must be a bank
+	err := check_parties(stub, d.Owner, d.Issuer, d.DataJSON)
+	if err != nil {
+		return err
+	}
+	err = check_document_operation(stub, d.DocumentType, OpIssue, "DataJSON", d.DataJSON)
+	if err != nil {
+		return err
+	}
+	terms, err := parseTerms(d.DataJSON)
+	if err != nil {
+		return err
+	}
+	err = checkExpiryDate(stub, d.ExpiryDate)
+	if err != nil {
+		return err
+	}
+
+	d.Status = DocStatusIssued
+	d.PreviousUid = ""
+	d.AvailableAmount = terms.Amount
+	return t.insert(stub, d)
+}
+
+// insert adds a new document, stamped with the transaction time
+func (t *Document) insert(stub Stub, d DocumentRecord) error {
 
+	//time
+	createdTime, err := timestamp(stub)
+	if err != nil {
+		return err
+	}
+	d.CreatedAt = createdTime
+
+	// The UID must be unique across all owners and issuers
+	err = put_uid_index(stub, documentUidIndex, d.Uid, d.Owner, d.Issuer, d.DocumentType)
+	if err != nil {
+		return err
+	}
 
 	// Insert a row
-	ok, err := stub.InsertRow("DocumentTable", shim.Row{
-		Columns: []*shim.Column{
-			&shim.Column{Value: &shim.Column_String_{String_: owner}},
-			&shim.Column{Value: &shim.Column_String_{String_: issuer}},
-      &shim.Column{Value: &shim.Column_String_{String_: documentType}},
-			&shim.Column{Value: &shim.Column_String_{String_: uid}},
-      &shim.Column{Value: &shim.Column_Bytes{Bytes: dataJSON}},
-      &shim.Column{Value: &shim.Column_String_{String_: status}},
-      &shim.Column{Value: &shim.Column_Bytes{Bytes: permissions}},
-      &shim.Column{Value: &shim.Column_String_{String_: expiryDate}},
-      &shim.Column{Value: &shim.Column_String_{String_: previousUid}},
-      &shim.Column{Value: &shim.Column_String_{String_: createdTime.Format(time.RFC3339)}}},
-	})
+	ok, err := stub.InsertRow("DocumentTable", d.toRow())
+
+	if !ok && err == nil {
+		return alreadyExists(ErrorDetails{"kind": AuditKindDocument, "uid": d.Uid}, "Document with uid %s already exists.", d.Uid)
+	}
+	if err != nil {
+		return err
+	}
+
+	err = put_expiry_index(stub, d)
+	if err != nil {
+		return err
+	}
+
+	err = audit(stub, AuditKindDocument, d.Uid, []string{d.Owner, d.Issuer, d.DocumentType, d.Uid}, "", d.Status)
+	if err != nil {
+		return err
+	}
+
+	name := EventDocumentIssued
+	if d.PreviousUid != "" {
+		name = EventDocumentAmended
+	}
+	return emit_event(stub, name, d.Uid, d.eventParties(), "", d.Status)
+}
+
+// replace overwrites an existing document
+func (t *Document) replace(stub Stub, d DocumentRecord) error {
+
+	// Key columns never change, only documents issued before the index existed need an entry
+	err := ensure_uid_index(stub, documentUidIndex, d.Uid, d.Owner, d.Issuer, d.DocumentType)
+	if err != nil {
+		return err
+	}
+
+	old, err := t.get(stub, d.Owner, d.Issuer, d.DocumentType, d.Uid)
+	if err != nil {
+		return err
+	}
+
+	ok, err := stub.ReplaceRow("DocumentTable", d.toRow())
 
 	if !ok && err == nil {
-		return nil, errors.New("Document already exists.")
+		return notFound(ErrorDetails{"kind": AuditKindDocument, "uid": d.Uid}, "Document with uid %s does not exist.", d.Uid)
+	}
+	if err != nil {
+		return err
 	}
 
-	return nil, err
+	// The expiry date never changes, only leaving the issued status moves the document out of the expiry index
+	if d.Status != old.Status {
+		err = put_expiry_index(stub, d)
+		if err != nil {
+			return err
+		}
+	}
+
+	err = audit(stub, AuditKindDocument, d.Uid, []string{d.Owner, d.Issuer, d.DocumentType, d.Uid}, old.Status, d.Status)
+	if err != nil {
+		return err
+	}
+
+	// Superseded versions are announced by the amendment, expiry is not a lifecycle event
+	if d.Status == DocStatusCancelled && old.Status != DocStatusCancelled {
+		return emit_event(stub, EventDocumentCancelled, d.Uid, d.eventParties(), old.Status, d.Status)
+	}
+	return nil
+}
+
+// eventParties returns the parties of a document named in its events
+func (d DocumentRecord) eventParties() EventParties {
+	terms, _ := parseTerms(d.DataJSON)
+	return EventParties{Owner: d.Owner, Issuer: d.Issuer, Beneficiary: terms.Beneficiary}
 }
 
-// GetDocument () – returns as JSON a single document w.r.t. the UID
-func (t *Document) GetLgJSON(stub *shim.ChaincodeStub, args []string) ([]byte, error) {
+// readers returns the parties that can read a document whose Permissions grant nothing: its owner,
+// its issuer and the beneficiary named in its DataJSON
+func (d DocumentRecord) readers() []string {
+	parties := []string{d.Owner, d.Issuer}
+	if terms, _ := parseTerms(d.DataJSON); terms.Beneficiary != "" {
+		parties = append(parties, terms.Beneficiary)
+	}
+	return parties
+}
+
+// GetLgJSON () – returns as JSON a single LG w.r.t. the UID
+func (t *Document) GetLgJSON(stub Stub, args []string) ([]byte, error) {
 
 	if len(args) != 3 {
-		return nil, errors.New("Incorrect number of arguments. Expecting 3.")
+		return nil, argCount("3")
 	}
+	return t.GetDocument(stub, []string{args[0], args[1], "LG", args[2]})
+}
 
+// GetDocument () – returns as JSON a single document of any registered type w.r.t. the UID
+func (t *Document) GetDocument(stub Stub, args []string) ([]byte, error) {
+
+	if len(args) != 4 {
+		return nil, argCount("4")
+	}
+
+	owner := args[0]
+	issuer := args[1]
+	documentType := args[2]
+	uid := args[3]
+
+	if _, err := get_document_type(stub, documentType); err != nil {
+		return nil, err
+	}
 
-  //requestType := args[1]
-  owner := args[0]
-  issuer := args[1]
-  documentType := "LG"
-	uid := args[2]
-  //requester := "testUser"
 	// Get the row pertaining to this UID
-  var columns []shim.Column
-  	col1 := shim.Column{Value: &shim.Column_String_{String_: owner}}
-  	columns = append(columns, col1)
-
-  	col2 := shim.Column{Value: &shim.Column_String_{String_: issuer}}
-  	columns = append(columns, col2)
-    col3 := shim.Column{Value: &shim.Column_String_{String_: documentType}}
-    columns = append(columns, col3)
-		col4 := shim.Column{Value: &shim.Column_String_{String_: uid}}
-    columns = append(columns, col4)
-
-  	row, err := stub.GetRow("DocumentTable", columns)
-  	if err != nil {
-  		return nil, fmt.Errorf("Error: Failed retrieving document with uid %s. Error %s", uid, err.Error())
-  	}
-
-  	// GetRows returns empty message if key does not exist
-  	if len(row.Columns) == 0 {
-  		return nil, nil
-  	}
-    fmt.Printf("UID\n")
-    fmt.Printf(`"UID": "`+row.Columns[3].GetString_()+`"`)
-    str := `{ "owner": "`+row.Columns[0].GetString_()+`", "issuer": "` + row.Columns[1].GetString_()+`", "documentType": "` + row.Columns[2].GetString_()+`", "uid": "` + row.Columns[3].GetString_()+`", "data": ` + string(row.Columns[4].GetBytes()) +`, "status": "`+ row.Columns[5].GetString_() +`", "permissions": ` + string(row.Columns[6].GetBytes())+`, "expiryDate":"`+row.Columns[7].GetString_() + `", "previousUid":"`+row.Columns[8].GetString_()+`", "createdAt": "` + row.Columns[9].GetString_() +`"  }`
-    fmt.Printf("JSON\n")
-    fmt.Printf(str)
-    //str := `{ "UID": `+row.Columns[0].GetString_()+`  }`
-  	return []byte(str), nil
-
-}
-
-// CancelLGDocument () – returns as JSON a single document w.r.t. the UID
-func (t *Document) CancelLGDocument(stub *shim.ChaincodeStub, args []string) ([]byte, error) {
+	row, err := stub.GetRow("DocumentTable", keyColumns(owner, issuer, documentType, uid))
+	if err != nil {
+		return nil, fmt.Errorf("Error: Failed retrieving document with uid %s. Error %s", uid, err.Error())
+	}
+
+	// GetRows returns empty message if key does not exist
+	if len(row.Columns) == 0 {
+		// Only the owner and issuer can learn that it does not exist
+		if err := check_party(stub, owner, issuer); err != nil {
+			return nil, err
+		}
+		return nil, notFound(ErrorDetails{"kind": AuditKindDocument, "uid": uid}, "Document with uid %s does not exist.", uid)
+	}
+	logger.Debugf("UID " + row.Columns[3].GetString_())
+
+	// The document's Permissions decide who can read it, by default its owner, issuer and beneficiary
+	d := documentFromRow(row)
+	if err := check_permission(stub, d.Permissions, RightRead, d.readers()...); err != nil {
+		return nil, err
+	}
+
+	return json.Marshal(d)
+
+}
+
+// GetDocuments () – lists the documents of an owner, optionally only those from one issuer
+func (t *Document) GetDocuments(stub Stub, args []string) ([]byte, error) {
+
+	if len(args) < 1 || len(args) > 3 {
+		return nil, argCount("1 to 3")
+	}
+	key := []string{args[0]}
+	parties := []string{args[0]}
+	if len(args) > 1 && args[1] != "" {
+		key = append(key, args[1])
+		parties = append(parties, args[1])
+	}
+	o, err := listOptionsArg(args, 2)
+	if err != nil {
+		return nil, err
+	}
+
+	// The owner can list all its documents, an issuer only the ones it issued to the owner
+	if err := check_party(stub, parties...); err != nil {
+		return nil, err
+	}
+
+	rows, err := stub.GetRows("DocumentTable", keyColumns(key...))
+	if err != nil {
+		return nil, fmt.Errorf("Failed to retrieve rows")
+	}
+
+	documents := []DocumentRecord{}
+	for row := range rows {
+		if len(row.Columns) != 0 {
+			d := documentFromRow(row)
+			if o.match(d.Status, d.CreatedAt, d.DocumentType) {
+				documents = append(documents, d)
+			}
+		}
+	}
+
+	// Listed in key order
+	documentKey := func(d DocumentRecord) listKey {
+		return listKey{d.Issuer, d.DocumentType, d.Uid}
+	}
+	sort.Slice(documents, func(i, j int) bool {
+		return compareKeys(documentKey(documents[i]), documentKey(documents[j])) < 0
+	})
+	keys := make([]listKey, len(documents))
+	for i, d := range documents {
+		keys[i] = documentKey(d)
+	}
+
+	first, last, next, err := paginate(keys, o, false)
+	if err != nil {
+		return nil, err
+	}
+
+	page := documents[first:last]
+	return json.Marshal(ListResponse{Count: len(page), Data: page, NextCursor: next})
+}
+
+// GetLgByUid () – returns as JSON a single document found by its UID alone
+func (t *Document) GetLgByUid(stub Stub, args []string) ([]byte, error) {
+
+	if len(args) != 1 {
+		return nil, argCount("1")
+	}
+	uid := args[0]
+
+	d, err := t.getByUid(stub, uid)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := get_document_type(stub, d.DocumentType); err != nil {
+		return nil, err
+	}
+
+	// The document's Permissions decide who can read it, by default its owner, issuer and beneficiary
+	if err := check_permission(stub, d.Permissions, RightRead, d.readers()...); err != nil {
+		return nil, err
+	}
+
+	return json.Marshal(d)
+}
+
+// RebuildIndexes () – indexes every document written before the UID and expiry indexes existed
+func (t *Document) RebuildIndexes(stub Stub) (int, error) {
+
+	rows, err := stub.GetRows("DocumentTable", keyColumns())
+	if err != nil {
+		return 0, fmt.Errorf("Failed to retrieve rows")
+	}
+
+	// Collect first, the rows channel must be drained before writing
+	var documents []DocumentRecord
+	for row := range rows {
+		if len(row.Columns) != 0 {
+			documents = append(documents, documentFromRow(row))
+		}
+	}
+
+	count := 0
+	for _, d := range documents {
+		err = put_expiry_index(stub, d)
+		if err != nil {
+			return count, err
+		}
+
+		key, found, err := get_uid_index(stub, documentUidIndex, d.Uid)
+		if err != nil {
+			return count, err
+		}
+		if found {
+			if key[0] != d.Owner || key[1] != d.Issuer || key[2] != d.DocumentType {
+				logger.Warningf("Document uid " + d.Uid + " is used more than once, only the first is indexed")
+			}
+			continue
+		}
+		err = put_uid_index(stub, documentUidIndex, d.Uid, d.Owner, d.Issuer, d.DocumentType)
+		if err != nil {
+			return count, err
+		}
+		count++
+	}
+	return count, nil
+}
+
+// CancelLGDocument () – cancels a single LG w.r.t. the UID
+func (t *Document) CancelLGDocument(stub Stub, args []string) ([]byte, error) {
 
 	if len(args) != 3 {
-		return nil, errors.New("Incorrect number of arguments. Expecting 3.")
+		return nil, argCount("3")
 	}
+	return t.CancelDocument(stub, []string{args[0], args[1], "LG", args[2]})
+}
 
+// CancelDocument () – cancels a single document of any registered type w.r.t. the UID
+func (t *Document) CancelDocument(stub Stub, args []string) ([]byte, error) {
 
-  //requestType := args[1]
-  owner := args[0]
-  issuer := args[1]
-  documentType := "LG"
-	uid := args[2]
-  //requester := "testUser"
-	// Get the row pertaining to this UID
-  var columns []shim.Column
-	col1 := shim.Column{Value: &shim.Column_String_{String_: owner}}
-	columns = append(columns, col1)
-	col2 := shim.Column{Value: &shim.Column_String_{String_: issuer}}
-	columns = append(columns, col2)
-	col3 := shim.Column{Value: &shim.Column_String_{String_: documentType}}
-	columns = append(columns, col3)
-  col4 := shim.Column{Value: &shim.Column_String_{String_: uid}}
-  columns = append(columns, col4)
+	if len(args) != 4 {
+		return nil, argCount("4")
+	}
 
+	owner := args[0]
+	issuer := args[1]
+	documentType := args[2]
+	uid := args[3]
 
+	if err := check_document_operation(stub, documentType, OpCancel, "", nil); err != nil {
+		return nil, err
+	}
 
+	d, err := t.get(stub, owner, issuer, documentType, uid)
+	if err != nil {
+		return nil, err
+	}
 
-  	row, err := stub.GetRow("DocumentTable", columns)
-  	if err != nil {
-  		return nil, fmt.Errorf("Error: Failed retrieving document with uid %s. Error %s", uid, err.Error())
-  	}
+	// Only the issuer can cancel the document, its Permissions can restrict that further
+	if err := check_permission(stub, d.Permissions, RightCancel, issuer); err != nil {
+		return nil, err
+	}
+
+	// Only a live document can be cancelled
+	if err := t.checkLive(stub, d, "cancelled"); err != nil {
+		return nil, err
+	}
+
+	d.Status = DocStatusCancelled
+	return nil, t.replace(stub, d)
+}
+
+// AmendDocument writes a new version of an issued document that points at the previous
+// one through PreviousUid, and marks the previous version superseded
+func (t *Document) AmendDocument(stub Stub, owner string, issuer string, documentType string, previousUid string, uid string, dataJSON []byte, permissions []byte, expiryDate string, requestUid string) error {
+
+	if uid == previousUid {
+		return invalidArgument(ErrorDetails{"field": "uid"}, "An amendment must have its own document uid.")
+	}
+	if err := check_parties(stub, owner, issuer, dataJSON); err != nil {
+		return err
+	}
+	if err := check_document_operation(stub, documentType, OpAmend, "DataJSON", dataJSON); err != nil {
+		return err
+	}
+
+	previous, err := t.get(stub, owner, issuer, documentType, previousUid)
+	if err != nil {
+		return err
+	}
+	if err := t.checkLive(stub, previous, "amended"); err != nil {
+		return err
+	}
+
+	// Amounts already paid out stay paid out on the new version
+	previousTerms, err := parseTerms(previous.DataJSON)
+	if err != nil {
+		return err
+	}
+	terms, err := parseTerms(dataJSON)
+	if err != nil {
+		return err
+	}
+	paid := previousTerms.Amount - previous.AvailableAmount
+	if terms.Amount < paid {
+		return conflict(ErrorDetails{"uid": previousUid, "paid": paid}, "The amended amount is lower than the %d already paid out", paid)
+	}
+
+	// Terms that are not amended carry over from the previous version
+	if expiryDate == "" {
+		expiryDate = previous.ExpiryDate
+	} else if err := checkExpiryDate(stub, expiryDate); err != nil {
+		return err
+	}
+	// An amendment sent without Permissions, read back from its request as null, keeps the list
+	if len(permissions) == 0 || string(permissions) == "null" {
+		permissions = previous.Permissions
+	}
 
-  	// GetRows returns empty message if key does not exist
-  	if len(row.Columns) == 0 {
-  		return nil, nil
-  	}
+	previous.Status = DocStatusSuperseded
+	err = t.replace(stub, previous)
+	if err != nil {
+		return err
+	}
 
-		fmt.Printf(row.Columns[0].GetString_())
-		fmt.Printf("Updating")
+	return t.insert(stub, DocumentRecord{
+		Owner:           owner,
+		Issuer:          issuer,
+		DocumentType:    documentType,
+		Uid:             uid,
+		DataJSON:        dataJSON,
+		Status:          DocStatusIssued,
+		Permissions:     permissions,
+		ExpiryDate:      expiryDate,
+		PreviousUid:     previousUid,
+		RequestUid:      requestUid,
+		AvailableAmount: terms.Amount - paid,
+	})
+}
 
-		//update status
-		ok, err := stub.ReplaceRow("DocumentTable", shim.Row{
-			Columns: []*shim.Column{
-				&shim.Column{Value: &shim.Column_String_{String_: row.Columns[0].GetString_()}},
-				&shim.Column{Value: &shim.Column_String_{String_: row.Columns[1].GetString_()}},
-				&shim.Column{Value: &shim.Column_String_{String_: row.Columns[2].GetString_()}},
-				&shim.Column{Value: &shim.Column_String_{String_: row.Columns[3].GetString_()}},
-				&shim.Column{Value: &shim.Column_Bytes{Bytes: row.Columns[4].GetBytes()}},
-				&shim.Column{Value: &shim.Column_String_{String_: "cancelled"}},
-				&shim.Column{Value: &shim.Column_Bytes{Bytes: row.Columns[6].GetBytes()}},
-				&shim.Column{Value: &shim.Column_String_{String_: row.Columns[7].GetString_()}},
-				&shim.Column{Value: &shim.Column_String_{String_: row.Columns[8].GetString_()}},
-				&shim.Column{Value: &shim.Column_String_{String_: row.Columns[9].GetString_()}}},
-		})
+// ExpireDocuments () – moves the issued documents whose expiry date has passed to expired, oldest expiry date
+// first. Each call expires at most pageSize documents; nextCursor is set while more are due, and passing it back
+// continues after the last document of the page.
+func (t *Document) ExpireDocuments(stub Stub, args []string) ([]byte, error) {
 
-		if !ok && err == nil {
-			return nil, errors.New("Error updating.")
+	if len(args) > 1 {
+		return nil, argCount("0 or 1")
+	}
+	o, err := listOptionsArg(args, 0)
+	if err != nil {
+		return nil, err
+	}
+	if o.Status != "" || o.CreatedFrom != "" || o.CreatedTo != "" || o.DocumentType != "" {
+		return nil, invalidArgument(ErrorDetails{"field": "options"}, "Invalid list options: expire_documents only takes pageSize and cursor")
+	}
+	var after listKey
+	if o.Cursor != "" {
+		after, err = decodeCursor(o.Cursor)
+		if err != nil {
+			return nil, err
 		}
+	}
 
+	// Scan the expiry index in date order up to the first entry not yet due, reading one entry past the page
+	// to know whether another page follows. Collect first, the index rows are rewritten below.
+	var due []Row
+	more := false
+	err = stub.ScanRows(expiryIndex, keyColumns(), func(row Row) (bool, error) {
+		expiryDate, uid := row.Columns[0].GetString_(), row.Columns[1].GetString_()
+		if after != nil && compareKeys(listKey{expiryDate, uid}, after) <= 0 {
+			return true, nil
+		}
+		expired, err := isExpired(stub, expiryDate)
+		if err != nil || !expired {
+			return false, err
+		}
+		if len(due) == o.PageSize {
+			more = true
+			return false, nil
+		}
+		due = append(due, row)
+		return true, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	uids := []string{}
+	for _, row := range due {
+		expiryDate, uid := row.Columns[0].GetString_(), row.Columns[1].GetString_()
+		d, err := t.get(stub, row.Columns[2].GetString_(), row.Columns[3].GetString_(), row.Columns[4].GetString_(), uid)
+		if err != nil && !has_code(err, CodeNotFound) {
+			return nil, err
+		}
+		if err != nil || d.Status != DocStatusIssued {
+			// A stale entry, the document is gone or left the issued status without the index knowing
+			logger.Warningf("Removing stale expiry index entry of document " + uid)
+			err = stub.DeleteRow(expiryIndex, keyColumns(expiryDate, uid))
+		} else {
+			d.Status = DocStatusExpired
+			err = t.replace(stub, d)
+			uids = append(uids, d.Uid)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	next := ""
+	if more {
+		last := due[len(due)-1]
+		next = encodeCursor(listKey{last.Columns[0].GetString_(), last.Columns[1].GetString_()})
+	}
+	return json.Marshal(ListResponse{Count: len(uids), Data: uids, NextCursor: next})
+}
+
+// GetLgHistory () – returns every version of a document, oldest first
+func (t *Document) GetLgHistory(stub Stub, args []string) ([]byte, error) {
+
+	if len(args) != 3 && len(args) != 4 {
+		return nil, argCount("3 or 4")
+	}
+
+	owner := args[0]
+	issuer := args[1]
+	uid := args[2]
+	documentType := "LG"
+	if len(args) == 4 {
+		documentType = args[3]
+	}
+
+	if _, err := get_document_type(stub, documentType); err != nil {
 		return nil, err
+	}
+
+	// All versions share the owner, issuer and type of the document
+	rows, err := stub.GetRows("DocumentTable", keyColumns(owner, issuer, documentType))
+	if err != nil {
+		return nil, fmt.Errorf("Failed to retrieve rows")
+	}
+
+	byUid := make(map[string]DocumentRecord)
+	next := make(map[string]string)
+	for row := range rows {
+		if len(row.Columns) == 0 {
+			continue
+		}
+		d := documentFromRow(row)
+		byUid[d.Uid] = d
+		if d.PreviousUid != "" {
+			next[d.PreviousUid] = d.Uid
+		}
+	}
+
+	if _, ok := byUid[uid]; !ok {
+		// Only the owner and issuer can learn that it does not exist
+		if err := check_party(stub, owner, issuer); err != nil {
+			return nil, err
+		}
+		return nil, notFound(ErrorDetails{"kind": AuditKindDocument, "uid": uid}, "Document with uid %s does not exist.", uid)
+	}
+
+	// Walk back to the first version, then forward to the latest
+	first := uid
+	for i := 0; i < len(byUid); i++ {
+		previous := byUid[first].PreviousUid
+		if _, ok := byUid[previous]; !ok {
+			break
+		}
+		first = previous
+	}
+
+	// The Permissions of every version decide who can read it, by default its owner, issuer and beneficiary
+	chain := []DocumentRecord{}
+	for current, i := first, 0; current != "" && i < len(byUid); current, i = next[current], i+1 {
+		if err := check_permission(stub, byUid[current].Permissions, RightRead, byUid[current].readers()...); err != nil {
+			return nil, err
+		}
+		chain = append(chain, byUid[current])
+	}
+
+	return json.Marshal(ListResponse{Count: len(chain), Data: chain})
+}
 
+// checkLive returns an error unless the document is issued and has not reached its expiry date.
+// action describes what was attempted, for the error message.
+func (t *Document) checkLive(stub Stub, d DocumentRecord, action string) error {
+	if d.Status != DocStatusIssued {
+		return invalidTransition(ErrorDetails{"uid": d.Uid, "status": d.Status}, "Invalid transition: document %s is %s and can not be %s", d.Uid, d.Status, action)
+	}
+	expired, err := isExpired(stub, d.ExpiryDate)
+	if err != nil {
+		return err
+	}
+	if expired {
+		return invalidTransition(ErrorDetails{"uid": d.Uid, "expiryDate": d.ExpiryDate}, "Invalid transition: document %s expired on %s and can not be %s", d.Uid, d.ExpiryDate, action)
+	}
+	return nil
+}
+
+// checkExpiryDate returns an error unless expiryDate is a valid date that has not passed yet
+func checkExpiryDate(stub Stub, expiryDate string) error {
+	if _, err := parseExpiryDate(expiryDate); err != nil {
+		return err
+	}
+	expired, err := isExpired(stub, expiryDate)
+	if err != nil {
+		return err
+	}
+	if expired {
+		return invalidArgument(ErrorDetails{"field": "expiryDate"}, "Invalid expiry date %s: the date has already passed.", expiryDate)
+	}
+	return nil
+}
+
+// get returns a document, or an error if it does not exist
+func (t *Document) get(stub Stub, owner string, issuer string, documentType string, uid string) (DocumentRecord, error) {
+
+	row, err := stub.GetRow("DocumentTable", keyColumns(owner, issuer, documentType, uid))
+	if err != nil {
+		return DocumentRecord{}, fmt.Errorf("Error: Failed retrieving document with uid %s. Error %s", uid, err.Error())
+	}
+
+	// GetRows returns empty message if key does not exist
+	if len(row.Columns) == 0 {
+		return DocumentRecord{}, notFound(ErrorDetails{"kind": AuditKindDocument, "uid": uid}, "Document with uid %s does not exist.", uid)
+	}
+
+	return documentFromRow(row), nil
+}
+
+// getByUid returns a document found through the UID index
+func (t *Document) getByUid(stub Stub, uid string) (DocumentRecord, error) {
+	key, found, err := get_uid_index(stub, documentUidIndex, uid)
+	if err != nil {
+		return DocumentRecord{}, err
+	}
+	if !found {
+		return DocumentRecord{}, notFound(ErrorDetails{"kind": AuditKindDocument, "uid": uid}, "Document with uid %s does not exist.", uid)
+	}
+	return t.get(stub, key[0], key[1], key[2], uid)
+}
+
+// current returns the version of a document now in force: d itself, or the latest amendment of a
+// superseded d
+func (t *Document) current(stub Stub, d DocumentRecord) (DocumentRecord, error) {
+	if d.Status != DocStatusSuperseded {
+		return d, nil
+	}
+
+	// All versions share the owner, issuer and type of the document
+	rows, err := stub.GetRows("DocumentTable", keyColumns(d.Owner, d.Issuer, d.DocumentType))
+	if err != nil {
+		return DocumentRecord{}, fmt.Errorf("Failed to retrieve rows")
+	}
+	next := make(map[string]DocumentRecord)
+	for row := range rows {
+		if len(row.Columns) == 0 {
+			continue
+		}
+		v := documentFromRow(row)
+		if v.PreviousUid != "" {
+			next[v.PreviousUid] = v
+		}
+	}
+
+	for i := 0; d.Status == DocStatusSuperseded && i < len(next); i++ {
+		v, ok := next[d.Uid]
+		if !ok {
+			break
+		}
+		d = v
+	}
+	return d, nil
+}
+
+// debit lowers the amount of a document still available to claims
+func (t *Document) debit(stub Stub, d DocumentRecord, amount int64) error {
+	if amount <= 0 || amount > d.AvailableAmount {
+		return conflict(ErrorDetails{"uid": d.Uid, "availableAmount": d.AvailableAmount}, "Invalid amount %d: %d is available on document %s", amount, d.AvailableAmount, d.Uid)
+	}
+	d.AvailableAmount -= amount
+	return t.replace(stub, d)
 }
@@ -0,0 +1,255 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// issueDocument issues a document of BankA to CorpB directly
+func (h *testChaincode) issueDocument(documentType string, uid string, dataJSON string) {
+	h.t.Helper()
+	h.officer().mustInvoke("issue_document", "CorpB", "BankA", documentType, uid, dataJSON, DocStatusIssued, "{}", testExpiryDate)
+}
+
+func TestIssueDocument(t *testing.T) {
+	h := newTestChaincode(t)
+	h.issueDocument("PERFORMANCE_BOND", "D1", `{"amount": 500, "beneficiary": "SupplierC", "contractReference": "C-42"}`)
+
+	var d DocumentRecord
+	h.officer().mustQueryInto(&d, "get_document", "CorpB", "BankA", "PERFORMANCE_BOND", "D1")
+	if d.Status != DocStatusIssued || d.AvailableAmount != 500 || d.ExpiryDate != testExpiryDate {
+		t.Fatalf("unexpected document %+v", d)
+	}
+
+	_, err := h.invoke("issue_document", "CorpB", "BankA", "LG", "D1", `{"amount": 1}`, DocStatusIssued, "{}", testExpiryDate)
+	expectCode(t, err, CodeAlreadyExists)
+	_, err = h.invoke("issue_document", "CorpB", "BankA", "LG", "D2", `{"amount": 1}`, DocStatusIssued, "{}", "2016-12-31")
+	expectCode(t, err, CodeInvalidArgument)
+	_, err = h.invoke("issue_document", "CorpB", "BankA", "LG", "D2", `{"amount": 1}`, DocStatusIssued, "{}", "31/12/2017")
+	expectCode(t, err, CodeInvalidArgument)
+	_, err = h.invoke("issue_document", "CorpB", "BankZ", "LG", "D2", `{"amount": 1}`, DocStatusIssued, "{}", testExpiryDate)
+	expectCode(t, err, CodeForbidden)
+	_, err = h.invoke("issue_document", "CorpB", "BankA", "PERFORMANCE_BOND", "D2", `{"amount": 1}`, DocStatusIssued, "{}", testExpiryDate)
+	expectCode(t, err, CodeInvalidArgument)
+	for _, status := range []string{DocStatusCancelled, "whatever"} {
+		_, err = h.invoke("issue_document", "CorpB", "BankA", "LG", "D2", `{"amount": 1}`, status, "{}", testExpiryDate)
+		expectCode(t, err, CodeInvalidArgument)
+	}
+	h.mustInvoke("issue_document", "CorpB", "BankA", "LG", "D2", `{"amount": 1}`, "", "{}", testExpiryDate)
+	h.mustQueryInto(&d, "get_document", "CorpB", "BankA", "LG", "D2")
+	if d.Status != DocStatusIssued {
+		t.Fatalf("expected issued, got %s", d.Status)
+	}
+
+	// A document issued without a uid is given one
+	var created CreatedResponse
+	if err := json.Unmarshal(h.mustInvoke("issue_document", "CorpB", "BankA", "LG", "", `{"amount": 1}`, "", "{}", testExpiryDate), &created); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(created.Uid, "Dtx") {
+		t.Fatalf("unexpected uid %q", created.Uid)
+	}
+	h.mustQueryInto(&d, "get_document", "CorpB", "BankA", "LG", created.Uid)
+}
+
+func TestGetDocument(t *testing.T) {
+	h := newTestChaincode(t)
+	h.issueLG("R1", 1000)
+
+	var d DocumentRecord
+	h.applicant().mustQueryInto(&d, "get_lg_document_json", "CorpB", "BankA", "R1")
+	if d.Uid != "R1" || d.DocumentType != "LG" {
+		t.Fatalf("unexpected document %+v", d)
+	}
+
+	_, err := h.query("get_document", "CorpB", "BankA", "LG", "R9")
+	expectCode(t, err, CodeNotFound)
+	_, err = h.query("get_document", "CorpB", "BankA", "NO_SUCH_TYPE", "R1")
+	expectCode(t, err, CodeNotFound)
+	_, err = h.as("eve", RoleApplicant, "CorpE").query("get_lg_document_json", "CorpB", "BankA", "R1")
+	expectCode(t, err, CodeForbidden)
+}
+
+func TestGetLgByUid(t *testing.T) {
+	h := newTestChaincode(t)
+	h.issueLG("R1", 1000)
+
+	// The beneficiary named in the DataJSON can read the document
+	var d DocumentRecord
+	h.beneficiary().mustQueryInto(&d, "get_lg_by_uid", "R1")
+	if d.Owner != "CorpB" || d.Issuer != "BankA" {
+		t.Fatalf("unexpected document %+v", d)
+	}
+
+	_, err := h.query("get_lg_by_uid", "R9")
+	expectCode(t, err, CodeNotFound)
+	_, err = h.as("eve", RoleBeneficiary, "SupplierE").query("get_lg_by_uid", "R1")
+	expectCode(t, err, CodeForbidden)
+
+	// The beneficiary reads the document through every read path
+	h.beneficiary().mustQuery("get_document", "CorpB", "BankA", "LG", "R1")
+	h.mustQuery("get_lg_document_json", "CorpB", "BankA", "R1")
+	h.mustQuery("get_lg_history", "CorpB", "BankA", "R1")
+	_, err = h.as("eve", RoleBeneficiary, "SupplierE").query("get_lg_history", "CorpB", "BankA", "R1")
+	expectCode(t, err, CodeForbidden)
+
+	// Documents of a type missing from the registry can not be read
+	h.stub.DeleteRow("DocumentTypeTable", keyColumns("LG"))
+	_, err = h.beneficiary().query("get_lg_by_uid", "R1")
+	expectCode(t, err, CodeNotFound)
+}
+
+func TestGetDocumentPermissions(t *testing.T) {
+	h := newTestChaincode(t)
+	h.officer().mustInvoke("issue_document", "CorpB", "BankA", "LG", "D1", `{"amount": 1}`, DocStatusIssued, `{"parties": {"BankA": ["read", "cancel"]}}`, testExpiryDate)
+
+	// The list grants CorpB nothing, although it owns the document
+	_, err := h.applicant().query("get_lg_by_uid", "D1")
+	expectCode(t, err, CodeForbidden)
+	h.officer().mustQuery("get_lg_by_uid", "D1")
+	h.auditor().mustQuery("get_lg_by_uid", "D1")
+
+	// The history is read under the same list
+	_, err = h.applicant().query("get_lg_history", "CorpB", "BankA", "D1")
+	expectCode(t, err, CodeForbidden)
+	h.officer().mustQuery("get_lg_history", "CorpB", "BankA", "D1")
+	h.auditor().mustQuery("get_lg_history", "CorpB", "BankA", "D1")
+}
+
+func TestGetDocuments(t *testing.T) {
+	h := newTestChaincode(t)
+	h.issueLG("R2", 1000)
+	h.issueLG("R1", 1000)
+	h.issueDocument("BID_BOND", "D1", `{"amount": 10, "beneficiary": "SupplierC", "tenderReference": "T-1"}`)
+
+	var page listOf
+	h.applicant().mustQueryInto(&page, "get_documents", "CorpB")
+	var documents []DocumentRecord
+	json.Unmarshal(page.Data, &documents)
+	if page.Count != 3 || documents[0].Uid != "D1" || documents[1].Uid != "R1" || documents[2].Uid != "R2" {
+		t.Fatalf("unexpected documents %+v", documents)
+	}
+
+	h.mustQueryInto(&page, "get_documents", "CorpB", "BankA", `{"documentType": "LG", "pageSize": 1}`)
+	json.Unmarshal(page.Data, &documents)
+	if page.Count != 1 || documents[0].Uid != "R1" || page.NextCursor == "" {
+		t.Fatalf("unexpected page %+v", documents)
+	}
+
+	_, err := h.query("get_documents", "CorpZ")
+	expectCode(t, err, CodeForbidden)
+}
+
+func TestCancelDocument(t *testing.T) {
+	h := newTestChaincode(t)
+	h.issueLG("R1", 1000)
+	h.issueDocument("BID_BOND", "D1", `{"amount": 10, "beneficiary": "SupplierC", "tenderReference": "T-1"}`)
+
+	_, err := h.applicant().invoke("cancel_lg_document", "CorpB", "BankA", "R1")
+	expectCode(t, err, CodeForbidden)
+
+	h.officer().mustInvoke("cancel_lg_document", "CorpB", "BankA", "R1")
+	h.mustInvoke("cancel_document", "CorpB", "BankA", "BID_BOND", "D1")
+
+	var d DocumentRecord
+	h.mustQueryInto(&d, "get_lg_by_uid", "R1")
+	if d.Status != DocStatusCancelled {
+		t.Fatalf("expected cancelled, got %s", d.Status)
+	}
+	if last := h.stub.Events[len(h.stub.Events)-1]; last.Name != EventDocumentCancelled {
+		t.Fatalf("expected %s, got %s", EventDocumentCancelled, last.Name)
+	}
+
+	_, err = h.invoke("cancel_lg_document", "CorpB", "BankA", "R1")
+	expectCode(t, err, CodeInvalidTransition)
+	_, err = h.invoke("cancel_document", "CorpB", "BankA", "LG", "R9")
+	expectCode(t, err, CodeNotFound)
+}
+
+func TestExpireDocuments(t *testing.T) {
+	h := newTestChaincode(t)
+	h.issueLG("R1", 1000)
+	h.officer().mustInvoke("issue_document", "CorpB", "BankA", "LG", "D1", `{"amount": 1}`, DocStatusIssued, "{}", "2018-12-31")
+
+	// Nothing is due yet
+	var expired listOf
+	json.Unmarshal(h.officer().mustInvoke("expire_documents"), &expired)
+	if expired.Count != 0 {
+		t.Fatalf("expected nothing to expire, got %+v", expired)
+	}
+
+	// A document is valid up to and including its expiry date
+	h.stub.Time = time.Date(2018, 1, 1, 0, 0, 0, 0, time.UTC)
+	json.Unmarshal(h.admin().mustInvoke("expire_documents"), &expired)
+	var uids []string
+	json.Unmarshal(expired.Data, &uids)
+	if expired.Count != 1 || uids[0] != "R1" {
+		t.Fatalf("expected R1 to expire, got %+v", uids)
+	}
+
+	var d DocumentRecord
+	h.officer().mustQueryInto(&d, "get_lg_by_uid", "R1")
+	if d.Status != DocStatusExpired {
+		t.Fatalf("expected expired, got %s", d.Status)
+	}
+	_, err := h.beneficiary().invoke("submit_claim", "CorpB", "BankA", "R1", "C1", "10", "Unpaid invoice")
+	expectCode(t, err, CodeInvalidTransition)
+}
+
+// Expiry reads the expiry index oldest date first and stops after a page, leaving the rest to the next call
+func TestExpireDocumentsInPages(t *testing.T) {
+	h := newTestChaincode(t)
+	for i, expiryDate := range []string{"2017-03-01", "2017-01-15", "2017-02-01", "2017-05-01", "2018-01-01"} {
+		h.officer().mustInvoke("issue_document", "CorpB", "BankA", "LG", fmt.Sprintf("D%d", i), `{"amount": 1}`, DocStatusIssued, "{}", expiryDate)
+	}
+	h.officer().mustInvoke("cancel_document", "CorpB", "BankA", "LG", "D3")
+	h.stub.Time = time.Date(2017, 6, 1, 0, 0, 0, 0, time.UTC)
+
+	var expired listOf
+	var uids []string
+	json.Unmarshal(h.officer().mustInvoke("expire_documents", `{"pageSize": 2}`), &expired)
+	json.Unmarshal(expired.Data, &uids)
+	if !reflect.DeepEqual(uids, []string{"D1", "D2"}) || expired.NextCursor == "" {
+		t.Fatalf("expected the two oldest expiry dates first, got %+v %v", expired, uids)
+	}
+
+	// The cancelled D3 left the index, D4 is not due yet
+	json.Unmarshal(h.officer().mustInvoke("expire_documents", fmt.Sprintf(`{"pageSize": 2, "cursor": %q}`, expired.NextCursor)), &expired)
+	json.Unmarshal(expired.Data, &uids)
+	if !reflect.DeepEqual(uids, []string{"D0"}) || expired.NextCursor != "" {
+		t.Fatalf("expected D0 on the last page, got %+v %v", expired, uids)
+	}
+
+	var d DocumentRecord
+	h.officer().mustQueryInto(&d, "get_lg_by_uid", "D3")
+	if d.Status != DocStatusCancelled {
+		t.Fatalf("expected D3 to stay cancelled, got %s", d.Status)
+	}
+
+	_, err := h.officer().invoke("expire_documents", `{"status": "issued"}`)
+	expectCode(t, err, CodeInvalidArgument)
+}
+
+func TestGetLgHistory(t *testing.T) {
+	h := newTestChaincode(t)
+	h.issueLG("R1", 1000)
+
+	var history listOf
+	h.applicant().mustQueryInto(&history, "get_lg_history", "CorpB", "BankA", "R1", "LG")
+	if history.Count != 1 {
+		t.Fatalf("expected a single version, got %+v", history)
+	}
+
+	_, err := h.officer().query("get_lg_history", "CorpB", "BankA", "R9")
+	expectCode(t, err, CodeNotFound)
+	_, err = h.query("get_lg_history", "CorpB", "BankA", "R1", "NO_SUCH_TYPE")
+	expectCode(t, err, CodeNotFound)
+	_, err = h.as("eve", RoleApplicant, "CorpE").query("get_lg_history", "CorpB", "BankA", "R1")
+	expectCode(t, err, CodeForbidden)
+	_, err = h.query("get_lg_history", "CorpB", "BankA", "R9")
+	expectCode(t, err, CodeForbidden)
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+//==============================================================================================================================
+//	 Errors - Every error returned to a client is a ChaincodeError. Its Error() string is the JSON object
+//		{"code": "NOT_FOUND", "message": "Request with uid R1 does not exist.", "details": {"kind": "request", "uid": "R1"}}
+//	so clients can branch on the code. Codes are stable, messages may change.
+//==============================================================================================================================
+
+// Error codes
+const (
+	CodeNotFound          = "NOT_FOUND"          //The record does not exist
+	CodeAlreadyExists     = "ALREADY_EXISTS"     //A record with the same key or uid exists
+	CodeInvalidArgument   = "INVALID_ARGUMENT"   //An argument is missing, malformed or out of range
+	CodeForbidden         = "FORBIDDEN"          //The caller's role, party or permissions do not allow the call
+	CodeInvalidTransition = "INVALID_TRANSITION" //The record's status does not allow the change
+	CodeConflict          = "CONFLICT"           //The change contradicts the state of another record, e.g. amounts
+	CodeInternal          = "INTERNAL"           //The ledger failed or holds malformed data
+)
+
+// ErrorDetails holds the fields a client needs to act on an error, e.g. the uid that was not found
+type ErrorDetails map[string]interface{}
+
+// ChaincodeError is a typed error with a stable code
+type ChaincodeError struct {
+	Code    string       `json:"code"`
+	Message string       `json:"message"`
+	Details ErrorDetails `json:"details,omitempty"`
+}
+
+func (e *ChaincodeError) Error() string {
+	errAsBytes, err := json.Marshal(e)
+	if err != nil {
+		return e.Code + ": " + e.Message
+	}
+	return string(errAsBytes)
+}
+
+func newError(code string, details ErrorDetails, format string, a ...interface{}) error {
+	return &ChaincodeError{Code: code, Message: fmt.Sprintf(format, a...), Details: details}
+}
+
+func notFound(details ErrorDetails, format string, a ...interface{}) error {
+	return newError(CodeNotFound, details, format, a...)
+}
+
+func alreadyExists(details ErrorDetails, format string, a ...interface{}) error {
+	return newError(CodeAlreadyExists, details, format, a...)
+}
+
+func invalidArgument(details ErrorDetails, format string, a ...interface{}) error {
+	return newError(CodeInvalidArgument, details, format, a...)
+}
+
+func forbidden(details ErrorDetails, format string, a ...interface{}) error {
+	return newError(CodeForbidden, details, format, a...)
+}
+
+func invalidTransition(details ErrorDetails, format string, a ...interface{}) error {
+	return newError(CodeInvalidTransition, details, format, a...)
+}
+
+func conflict(details ErrorDetails, format string, a ...interface{}) error {
+	return newError(CodeConflict, details, format, a...)
+}
+
+func internalError(details ErrorDetails, format string, a ...interface{}) error {
+	return newError(CodeInternal, details, format, a...)
+}
+
+// argCount is the error of a call with the wrong number of arguments
+func argCount(expecting string) error {
+	return invalidArgument(ErrorDetails{"expecting": expecting}, "Incorrect number of arguments. Expecting %s.", expecting)
+}
+
+// error_message returns the message of an error without its code
+func error_message(err error) string {
+	if e, ok := err.(*ChaincodeError); ok {
+		return e.Message
+	}
+	return err.Error()
+}
+
+// has_code returns true if err is a ChaincodeError with the given code
+func has_code(err error, code string) bool {
+	e, ok := err.(*ChaincodeError)
+	return ok && e.Code == code
+}
+
+// as_chaincode_error returns err as a ChaincodeError. Errors that are not typed yet are reported as internal.
+func as_chaincode_error(err error) error {
+	if err == nil {
+		return nil
+	}
+	if _, ok := err.(*ChaincodeError); ok {
+		return err
+	}
+	return internalError(nil, "%s", err.Error())
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+)
+
+//==============================================================================================================================
+//	 Events - Lifecycle transitions are published as chaincode events so clients do not have to poll.
+//			  A transaction carries a single chaincode event: when one invoke makes several transitions, e.g.
+//			  approve_new_request approving a request and issuing its LG, the event is named after the last
+//			  transition and its payload lists all of them in order.
+//==============================================================================================================================
+
+// Event names subscribers can filter on
+const (
+	EventRequestSubmitted  = "request.submitted"
+	EventRequestApproved   = "request.approved"
+	EventRequestRejected   = "request.rejected"
+	EventDocumentIssued    = "document.issued"
+	EventDocumentAmended   = "document.amended"
+	EventDocumentCancelled = "document.cancelled"
+	EventClaimSubmitted    = "claim.submitted"
+	EventClaimPaid         = "claim.paid"
+	EventClaimRejected     = "claim.rejected"
+)
+
+// eventVersion is raised whenever a field of the payload changes meaning or is removed
+const eventVersion = 1
+
+// EventPayload is the JSON payload of every chaincode event
+type EventPayload struct {
+	Version     int              `json:"version"`
+	Transitions []LifecycleEvent `json:"transitions"`
+}
+
+// LifecycleEvent is one transition of a request, document or claim
+type LifecycleEvent struct {
+	Name      string       `json:"name"`
+	Uid       string       `json:"uid"`
+	Parties   EventParties `json:"parties"`
+	OldStatus string       `json:"oldStatus"`
+	NewStatus string       `json:"newStatus"`
+	TxId      string       `json:"txId"`
+	Timestamp string       `json:"timestamp"`
+}
+
+// EventParties names the parties of the record that changed
+type EventParties struct {
+	Requester   string `json:"requester,omitempty"`
+	Approver    string `json:"approver,omitempty"`
+	Owner       string `json:"owner,omitempty"`
+	Issuer      string `json:"issuer,omitempty"`
+	Beneficiary string `json:"beneficiary,omitempty"`
+}
+
+// emit_event adds a transition to the event of the transaction
+func emit_event(stub Stub, name string, uid string, parties EventParties, oldStatus string, newStatus string) error {
+
+	now, err := timestamp(stub)
+	if err != nil {
+		return err
+	}
+
+	invocation := stub.Invocation()
+	invocation.Transitions = append(invocation.Transitions, LifecycleEvent{
+		Name:      name,
+		Uid:       uid,
+		Parties:   parties,
+		OldStatus: oldStatus,
+		NewStatus: newStatus,
+		TxId:      stub.TxID(),
+		Timestamp: now,
+	})
+
+	payload, err := json.Marshal(EventPayload{Version: eventVersion, Transitions: invocation.Transitions})
+	if err != nil {
+		return errors.New("Error marshalling event " + name)
+	}
+	return stub.SetEvent(name, payload)
+}
+
+// requestEvent returns the event published when a request moves to status, if any
+func requestEvent(status string) string {
+	switch status {
+	case StatusSubmitted:
+		return EventRequestSubmitted
+	case StatusApproved:
+		return EventRequestApproved
+	case StatusRejected:
+		return EventRequestRejected
+	}
+	return ""
+}
+
+// claimEvent returns the event published when a claim moves to status
+func claimEvent(status string) string {
+	switch status {
+	case ClaimStatusSubmitted:
+		return EventClaimSubmitted
+	case ClaimStatusPaid, ClaimStatusPartiallyPaid:
+		return EventClaimPaid
+	case ClaimStatusRejected:
+		return EventClaimRejected
+	}
+	return ""
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"sort"
+	"strconv"
+
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+)
+
+//==============================================================================================================================
+//	 Entity indexes - An index lists the IDs of one kind of entity. Each ID has its own composite key (index, id),
+//					  so transactions registering different entities never write the same key, and listing an index
+//					  is a partial composite key query returning the IDs in order.
+//					  Ledgers written before kept an index as a single JSON array of IDs under the index name.
+//					  rebuild_indexes moves those arrays to the composite keys.
+//==============================================================================================================================
+
+// put_index_entry adds id to an index. The entry holds the id itself, so listing needs no key parsing.
+func put_index_entry(stub Stub, index string, id string) error {
+	key, err := shim.CreateCompositeKey(index, []string{id})
+	if err != nil {
+		return invalidArgument(ErrorDetails{"index": index, "id": id}, "Invalid id %s: %s", id, err.Error())
+	}
+	if err := stub.PutState(key, []byte(id)); err != nil {
+		return errors.New("Error storing " + index + " entry " + id)
+	}
+	return nil
+}
+
+// list_index returns the IDs of an index in ascending order
+func list_index(stub Stub, index string) ([]string, error) {
+	entries, err := stub.GetStateByPartialCompositeKey(index, []string{})
+	if err != nil {
+		return nil, errors.New("Failed to get " + index)
+	}
+	defer entries.Close()
+
+	var ids []string
+	for entries.HasNext() {
+		kv, err := entries.Next()
+		if err != nil {
+			return nil, errors.New("Failed to get " + index)
+		}
+		ids = append(ids, string(kv.Value))
+	}
+
+	// Not every state database returns keys in byte order
+	sort.Strings(ids)
+	return ids, nil
+}
+
+// clear_index deletes every entry of an index, and the legacy array if it is still there
+func clear_index(stub Stub, index string) error {
+	ids, err := list_index(stub, index)
+	if err != nil {
+		return err
+	}
+	for _, id := range ids {
+		key, _ := shim.CreateCompositeKey(index, []string{id})
+		if err := stub.DelState(key); err != nil {
+			return errors.New("Error deleting " + index + " entry " + id)
+		}
+	}
+	return stub.DelState(index)
+}
+
+// migrate_index moves the IDs of a legacy index array to their own keys and deletes the array.
+// It returns the number of IDs moved, 0 once the index has been migrated.
+func migrate_index(stub Stub, index string) (int, error) {
+	indexAsBytes, err := stub.GetState(index)
+	if err != nil {
+		return 0, errors.New("Failed to get " + index)
+	}
+	if len(indexAsBytes) == 0 {
+		return 0, nil
+	}
+
+	var ids []string
+	if err := json.Unmarshal(indexAsBytes, &ids); err != nil {
+		return 0, errors.New("Error unmarshalling " + index)
+	}
+	for _, id := range ids {
+		if err := put_index_entry(stub, index, id); err != nil {
+			return 0, err
+		}
+	}
+	if err := stub.DelState(index); err != nil {
+		return 0, errors.New("Error deleting " + index)
+	}
+	return len(ids), nil
+}
+
+//==============================================================================================================================
+//	 IDs - new_id allocates an ID without reading or writing any key. Transaction IDs are unique on the channel,
+//		   so an ID derived from the transaction ID and a sequence number within the transaction can not collide
+//		   with an ID allocated by another transaction.
+//==============================================================================================================================
+
+// new_id returns a new ID: prefix, the transaction ID and the number of the ID within the transaction
+func new_id(stub Stub, prefix string) string {
+	stub.Invocation().IDs++
+	return prefix + stub.TxID() + "-" + strconv.Itoa(stub.Invocation().IDs)
+}
@@ -0,0 +1,19 @@
+package main
+
+import "testing"
+
+func TestNewId(t *testing.T) {
+	s := NewMemStub()
+
+	var ids []string
+	for _, tx := range []string{"tx1", "tx2"} {
+		s.Transaction(tx, func() ([]byte, error) {
+			ids = append(ids, new_id(s, "ID"), new_id(s, "ID"))
+			return nil, nil
+		})
+	}
+
+	if ids[0] != "IDtx1-1" || ids[1] != "IDtx1-2" || ids[2] != "IDtx2-1" || ids[3] != "IDtx2-2" {
+		t.Fatalf("unexpected ids %v", ids)
+	}
+}
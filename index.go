@@ -0,0 +1,200 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+)
+
+//==============================================================================================================================
+//	 UID indexes - Tables are keyed by their parties, so finding a row by UID alone needs a secondary index.
+//				   Each index table maps a UID to the key columns of its row. Inserting an entry fails if the UID
+//				   is already taken, which makes UIDs unique across all parties.
+//==============================================================================================================================
+
+const (
+	documentUidIndex = "DocumentUidIndex"
+	requestUidIndex  = "RequestUidIndex"
+	approverIndex    = "ApproverIndex"
+	expiryIndex      = "ExpiryIndex"
+)
+
+// create_uid_index creates an index table if it does not exist yet
+func create_uid_index(stub Stub, index string) error {
+	_, err := stub.GetTable(index)
+	if err == nil {
+		// Table already exists; do not recreate
+		return nil
+	}
+
+	err = stub.CreateTable(index, []*ColumnDefinition{
+		&ColumnDefinition{Name: "Uid", Type: ColumnDefinition_STRING, Key: true},
+		&ColumnDefinition{Name: "Key", Type: ColumnDefinition_BYTES, Key: false},
+	})
+	if err != nil {
+		return errors.New("Failed creating " + index + ".")
+	}
+	return nil
+}
+
+// put_uid_index records the key columns of the row holding uid. It fails if uid already points to another row.
+func put_uid_index(stub Stub, index string, uid string, key ...string) error {
+	keyAsBytes, err := json.Marshal(key)
+	if err != nil {
+		return errors.New("Error marshalling index key")
+	}
+
+	ok, err := stub.InsertRow(index, Row{
+		Columns: []*Column{
+			&Column{Value: &Column_String_{String_: uid}},
+			&Column{Value: &Column_Bytes{Bytes: keyAsBytes}}},
+	})
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return alreadyExists(ErrorDetails{"index": index, "uid": uid}, "Uid %s is already in use.", uid)
+	}
+	return nil
+}
+
+// ensure_uid_index records the key of a row written before the index existed. Rows that already have an
+// entry are left alone.
+func ensure_uid_index(stub Stub, index string, uid string, key ...string) error {
+	_, found, err := get_uid_index(stub, index, uid)
+	if err != nil || found {
+		return err
+	}
+	return put_uid_index(stub, index, uid, key...)
+}
+
+// get_uid_index returns the key columns of the row holding uid
+func get_uid_index(stub Stub, index string, uid string) ([]string, bool, error) {
+	row, err := stub.GetRow(index, keyColumns(uid))
+	if err != nil {
+		return nil, false, fmt.Errorf("Error: Failed retrieving %s entry for uid %s. Error %s", index, uid, err.Error())
+	}
+	if len(row.Columns) == 0 {
+		return nil, false, nil
+	}
+
+	var key []string
+	err = json.Unmarshal(row.Columns[1].GetBytes(), &key)
+	if err != nil {
+		return nil, false, errors.New("Error unmarshalling index key")
+	}
+	return key, true, nil
+}
+
+//==============================================================================================================================
+//	 Approver index - The RequestTable can only be scanned by a prefix of its key (RequestType, Requester), so an
+//					  approver could not find the requests waiting for it. The approver index keeps one row per
+//					  request keyed by Approver first, carrying what the inbox filters and sorts on.
+//==============================================================================================================================
+
+// create_approver_index creates the approver index table if it does not exist yet
+func create_approver_index(stub Stub) error {
+	_, err := stub.GetTable(approverIndex)
+	if err == nil {
+		// Table already exists; do not recreate
+		return nil
+	}
+
+	err = stub.CreateTable(approverIndex, []*ColumnDefinition{
+		&ColumnDefinition{Name: "Approver", Type: ColumnDefinition_STRING, Key: true},
+		&ColumnDefinition{Name: "RequestType", Type: ColumnDefinition_STRING, Key: true},
+		&ColumnDefinition{Name: "Uid", Type: ColumnDefinition_STRING, Key: true},
+		&ColumnDefinition{Name: "Requester", Type: ColumnDefinition_STRING, Key: false},
+		&ColumnDefinition{Name: "Status", Type: ColumnDefinition_STRING, Key: false},
+		&ColumnDefinition{Name: "CreatedAt", Type: ColumnDefinition_STRING, Key: false},
+	})
+	if err != nil {
+		return errors.New("Failed creating " + approverIndex + ".")
+	}
+	return nil
+}
+
+// put_approver_index inserts or updates the approver index entry of a request
+func put_approver_index(stub Stub, r RequestRecord) error {
+	row := Row{
+		Columns: []*Column{
+			&Column{Value: &Column_String_{String_: r.Approver}},
+			&Column{Value: &Column_String_{String_: r.RequestType}},
+			&Column{Value: &Column_String_{String_: r.Uid}},
+			&Column{Value: &Column_String_{String_: r.Requester}},
+			&Column{Value: &Column_String_{String_: r.Status}},
+			&Column{Value: &Column_String_{String_: r.CreatedAt}}},
+	}
+
+	ok, err := stub.ReplaceRow(approverIndex, row)
+	if err != nil {
+		return err
+	}
+	if ok {
+		return nil
+	}
+
+	// No entry yet: a new request, or one submitted before the index existed
+	ok, err = stub.InsertRow(approverIndex, row)
+	if !ok && err == nil {
+		return errors.New("Error updating " + approverIndex + ".")
+	}
+	return err
+}
+
+//==============================================================================================================================
+//	 Expiry index - Finding the documents past their expiry date would otherwise mean reading the whole DocumentTable.
+//					The expiry index keeps one row per issued document keyed by ExpiryDate first, so expiry dates
+//					(YYYY-MM-DD) sort by day and expire_documents reads only the entries that are due.
+//==============================================================================================================================
+
+// create_expiry_index creates the expiry index table if it does not exist yet
+func create_expiry_index(stub Stub) error {
+	_, err := stub.GetTable(expiryIndex)
+	if err == nil {
+		// Table already exists; do not recreate
+		return nil
+	}
+
+	err = stub.CreateTable(expiryIndex, []*ColumnDefinition{
+		&ColumnDefinition{Name: "ExpiryDate", Type: ColumnDefinition_STRING, Key: true},
+		&ColumnDefinition{Name: "Uid", Type: ColumnDefinition_STRING, Key: true},
+		&ColumnDefinition{Name: "Owner", Type: ColumnDefinition_STRING, Key: false},
+		&ColumnDefinition{Name: "Issuer", Type: ColumnDefinition_STRING, Key: false},
+		&ColumnDefinition{Name: "DocumentType", Type: ColumnDefinition_STRING, Key: false},
+	})
+	if err != nil {
+		return errors.New("Failed creating " + expiryIndex + ".")
+	}
+	return nil
+}
+
+// put_expiry_index adds the expiry index entry of an issued document and removes it once the document
+// leaves the issued status. Documents without a valid expiry date never expire and have no entry.
+func put_expiry_index(stub Stub, d DocumentRecord) error {
+	if _, err := parseExpiryDate(d.ExpiryDate); err != nil {
+		return nil
+	}
+	if d.Status != DocStatusIssued {
+		return stub.DeleteRow(expiryIndex, keyColumns(d.ExpiryDate, d.Uid))
+	}
+
+	row := Row{
+		Columns: []*Column{
+			&Column{Value: &Column_String_{String_: d.ExpiryDate}},
+			&Column{Value: &Column_String_{String_: d.Uid}},
+			&Column{Value: &Column_String_{String_: d.Owner}},
+			&Column{Value: &Column_String_{String_: d.Issuer}},
+			&Column{Value: &Column_String_{String_: d.DocumentType}}},
+	}
+
+	ok, err := stub.ReplaceRow(expiryIndex, row)
+	if err != nil || ok {
+		return err
+	}
+	ok, err = stub.InsertRow(expiryIndex, row)
+	if !ok && err == nil {
+		return errors.New("Error updating " + expiryIndex + ".")
+	}
+	return err
+}
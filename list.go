@@ -0,0 +1,142 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"sort"
+)
+
+//==============================================================================================================================
+//	 List options - Every list query takes an optional trailing JSON argument:
+//		{"pageSize": 50, "cursor": "...", "status": "submitted", "createdFrom": "2016-01-01T00:00:00Z",
+//		 "createdTo": "2017-01-01T00:00:00Z", "documentType": "LG"}
+//	All fields are optional. createdFrom is inclusive and createdTo exclusive, both RFC3339 like createdAt.
+//	list_users also takes "organisation" and "role", list_organisations "type", other lists ignore them.
+//	The cursor is opaque: clients pass back the nextCursor of the previous page unchanged.
+//==============================================================================================================================
+
+const (
+	defaultPageSize = 50
+	maxPageSize     = 500
+)
+
+type listOptions struct {
+	PageSize     int    `json:"pageSize"`
+	Cursor       string `json:"cursor"`
+	Status       string `json:"status"`
+	CreatedFrom  string `json:"createdFrom"`
+	CreatedTo    string `json:"createdTo"`
+	DocumentType string `json:"documentType"`
+	Organisation string `json:"organisation"`
+	Role         string `json:"role"`
+	Type         string `json:"type"`
+}
+
+// parseListOptions reads the list options argument. An empty argument selects the first page without filters.
+func parseListOptions(arg string) (listOptions, error) {
+	o := listOptions{PageSize: defaultPageSize}
+	if arg == "" {
+		return o, nil
+	}
+
+	dec := json.NewDecoder(bytes.NewReader([]byte(arg)))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&o); err != nil {
+		return o, invalidArgument(ErrorDetails{"field": "options"}, "Invalid list options: %s", err.Error())
+	}
+	if o.PageSize == 0 {
+		o.PageSize = defaultPageSize
+	}
+	if o.PageSize < 0 || o.PageSize > maxPageSize {
+		return o, invalidArgument(ErrorDetails{"field": "options", "pageSize": o.PageSize}, "Invalid list options: pageSize must be between 1 and %d", maxPageSize)
+	}
+	return o, nil
+}
+
+// listOptionsArg returns the list options passed as args[n], if any
+func listOptionsArg(args []string, n int) (listOptions, error) {
+	if len(args) > n {
+		return parseListOptions(args[n])
+	}
+	return parseListOptions("")
+}
+
+// match returns true if a record with the given status, createdAt and document type passes the filters
+func (o listOptions) match(status string, createdAt string, documentType string) bool {
+	if o.Status != "" && o.Status != status {
+		return false
+	}
+	if o.CreatedFrom != "" && createdAt < o.CreatedFrom {
+		return false
+	}
+	if o.CreatedTo != "" && createdAt >= o.CreatedTo {
+		return false
+	}
+	if o.DocumentType != "" && o.DocumentType != documentType {
+		return false
+	}
+	return true
+}
+
+// listKey is the sort key of a listed record. Pages are cut along it and cursors carry the key of the
+// last record of a page, so a page stays correct when records before the cursor change.
+type listKey []string
+
+func compareKeys(a, b listKey) int {
+	for i := 0; i < len(a) && i < len(b); i++ {
+		if a[i] != b[i] {
+			if a[i] < b[i] {
+				return -1
+			}
+			return 1
+		}
+	}
+	return len(a) - len(b)
+}
+
+func encodeCursor(k listKey) string {
+	keyAsBytes, _ := json.Marshal(k)
+	return base64.RawURLEncoding.EncodeToString(keyAsBytes)
+}
+
+func decodeCursor(cursor string) (listKey, error) {
+	var k listKey
+	keyAsBytes, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err == nil {
+		err = json.Unmarshal(keyAsBytes, &k)
+	}
+	if err != nil {
+		return nil, invalidArgument(ErrorDetails{"field": "options"}, "Invalid list options: malformed cursor")
+	}
+	return k, nil
+}
+
+// paginate returns the bounds of the requested page within keys and the cursor of the next page.
+// keys must be sorted in listing order: ascending, or descending if desc is set.
+func paginate(keys []listKey, o listOptions, desc bool) (int, int, string, error) {
+	start := 0
+	if o.Cursor != "" {
+		last, err := decodeCursor(o.Cursor)
+		if err != nil {
+			return 0, 0, "", err
+		}
+		start = sort.Search(len(keys), func(i int) bool {
+			if desc {
+				return compareKeys(keys[i], last) < 0
+			}
+			return compareKeys(keys[i], last) > 0
+		})
+	}
+
+	end := start + o.PageSize
+	if end > len(keys) {
+		end = len(keys)
+	}
+
+	next := ""
+	if end < len(keys) {
+		next = encodeCursor(keys[end-1])
+	}
+	return start, end, next, nil
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"log"
+	"os"
+	"strings"
+)
+
+//==============================================================================================================================
+//	 Logger - The chaincode shim of Fabric 1.x and later no longer provides a logger. Logger writes levelled
+//			  messages to the standard error of the chaincode container, where the peer collects them.
+//==============================================================================================================================
+
+type LogLevel int
+
+const (
+	LogDebug LogLevel = iota
+	LogInfo
+	LogWarning
+)
+
+type Logger struct {
+	level LogLevel
+	log   *log.Logger
+}
+
+func NewLogger(name string) *Logger {
+	return &Logger{level: LogDebug, log: log.New(os.Stderr, name+" ", log.LstdFlags)}
+}
+
+// SetLevel sets the lowest level logged from its name: DEBUG, INFO or WARNING. Other names leave the level unchanged.
+func (l *Logger) SetLevel(level string) {
+	switch strings.ToUpper(level) {
+	case "DEBUG":
+		l.level = LogDebug
+	case "INFO":
+		l.level = LogInfo
+	case "WARNING":
+		l.level = LogWarning
+	}
+}
+
+func (l *Logger) Debugf(format string, args ...interface{}) {
+	l.logf(LogDebug, "DEBU", format, args...)
+}
+
+func (l *Logger) Infof(format string, args ...interface{}) {
+	l.logf(LogInfo, "INFO", format, args...)
+}
+
+func (l *Logger) Warningf(format string, args ...interface{}) {
+	l.logf(LogWarning, "WARN", format, args...)
+}
+
+func (l *Logger) logf(level LogLevel, prefix string, format string, args ...interface{}) {
+	if level >= l.level {
+		l.log.Printf(prefix+" "+format, args...)
+	}
+}
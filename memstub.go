@@ -0,0 +1,242 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+	"github.com/hyperledger/fabric-protos-go/ledger/queryresult"
+)
+
+//==============================================================================================================================
+//	 MemStub - An in-memory Stub for tests. It keeps state in a map, with the tables of table.go on top of it, and
+//			   runs one transaction at a time: Begin starts a transaction, Commit keeps its changes and Rollback
+//			   discards them, as a peer discards the writes of a transaction that returned an error. Like a peer,
+//			   it keeps the writes of a transaction apart until Commit, so the transaction reads the state
+//			   committed before it began and never its own writes. Only the last event set by a transaction is
+//			   published, on Commit.
+//==============================================================================================================================
+
+type MemStub struct {
+	*tables
+
+	Time       time.Time         //Timestamp of the transactions
+	Attributes map[string]string //Certificate attributes of the caller
+	Transient  map[string][]byte //Transient data of the transactions, never written to the ledger
+	Events     []MemEvent        //Events published by committed transactions, oldest first
+
+	txID       string
+	invocation *Invocation
+	state      map[string][]byte
+	pending    map[string][]byte //Writes of the running transaction, nil for a deleted key
+	event      *MemEvent
+}
+
+// MemEvent is a chaincode event published by a transaction
+type MemEvent struct {
+	TxID    string
+	Name    string
+	Payload []byte
+}
+
+var _ Stub = (*MemStub)(nil)
+
+func NewMemStub() *MemStub {
+	s := &MemStub{
+		Attributes: map[string]string{},
+		state:      map[string][]byte{},
+	}
+	s.tables = newTables(s)
+	s.invocation = &Invocation{}
+	return s
+}
+
+//==============================================================================================================================
+//	 Transactions
+//==============================================================================================================================
+
+// Begin starts a transaction with the given ID
+func (s *MemStub) Begin(txID string) error {
+	if s.pending != nil {
+		return errors.New("Transaction " + s.txID + " is still running")
+	}
+	s.txID = txID
+	s.invocation = &Invocation{}
+	s.event = nil
+	s.pending = map[string][]byte{}
+	s.tables = newTables(s)
+	return nil
+}
+
+// Commit keeps the changes of the transaction and publishes its event.
+// Outside a transaction, writes are committed as they are made.
+func (s *MemStub) Commit() {
+	for k, v := range s.pending {
+		if v == nil {
+			delete(s.state, k)
+		} else {
+			s.state[k] = v
+		}
+	}
+	if s.event != nil {
+		s.Events = append(s.Events, *s.event)
+	}
+	s.tables = newTables(s)
+	s.event = nil
+	s.pending = nil
+}
+
+// Rollback discards the changes of the transaction
+func (s *MemStub) Rollback() {
+	s.tables = newTables(s)
+	s.event = nil
+	s.pending = nil
+}
+
+// Transaction runs f in a transaction, committed if f succeeds and rolled back otherwise
+func (s *MemStub) Transaction(txID string, f func() ([]byte, error)) ([]byte, error) {
+	if err := s.Begin(txID); err != nil {
+		return nil, err
+	}
+	payload, err := f()
+	if err != nil {
+		s.Rollback()
+		return nil, err
+	}
+	s.Commit()
+	return payload, nil
+}
+
+//==============================================================================================================================
+//	 State
+//==============================================================================================================================
+
+// GetState reads the committed state, not the writes of the running transaction
+func (s *MemStub) GetState(key string) ([]byte, error) {
+	return s.state[key], nil
+}
+
+func (s *MemStub) PutState(key string, value []byte) error {
+	if key == "" {
+		return errors.New("Key can not be empty")
+	}
+	s.write(key, append([]byte{}, value...))
+	return nil
+}
+
+func (s *MemStub) DelState(key string) error {
+	s.write(key, nil)
+	return nil
+}
+
+// write adds a write to the running transaction, or commits it outside a transaction
+func (s *MemStub) write(key string, value []byte) {
+	if s.pending != nil {
+		s.pending[key] = value
+		return
+	}
+	if value == nil {
+		delete(s.state, key)
+	} else {
+		s.state[key] = value
+	}
+}
+
+//==============================================================================================================================
+//	 Partial composite key queries
+//==============================================================================================================================
+
+// GetStateByPartialCompositeKey returns the keys of an object type starting with the given attributes, in key order
+func (s *MemStub) GetStateByPartialCompositeKey(objectType string, attributes []string) (shim.StateQueryIteratorInterface, error) {
+	prefix, err := shim.CreateCompositeKey(objectType, attributes)
+	if err != nil {
+		return nil, err
+	}
+	var keys []string
+	for k := range s.state {
+		if strings.HasPrefix(k, prefix) {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+
+	results := make([]*queryresult.KV, len(keys))
+	for i, k := range keys {
+		results[i] = &queryresult.KV{Key: k, Value: append([]byte(nil), s.state[k]...)}
+	}
+	return &memIterator{results: results}, nil
+}
+
+// memIterator iterates over the results of a query, read when the query was made
+type memIterator struct {
+	results []*queryresult.KV
+}
+
+func (it *memIterator) HasNext() bool {
+	return len(it.results) > 0
+}
+
+func (it *memIterator) Next() (*queryresult.KV, error) {
+	if len(it.results) == 0 {
+		return nil, errors.New("No more results")
+	}
+	kv := it.results[0]
+	it.results = it.results[1:]
+	return kv, nil
+}
+
+func (it *memIterator) Close() error {
+	it.results = nil
+	return nil
+}
+
+//==============================================================================================================================
+//	 Events, transaction and caller
+//==============================================================================================================================
+
+// SetEvent sets the event of the transaction, replacing any event set before
+func (s *MemStub) SetEvent(name string, payload []byte) error {
+	if name == "" {
+		return errors.New("Event name can not be empty")
+	}
+	s.event = &MemEvent{TxID: s.txID, Name: name, Payload: append([]byte(nil), payload...)}
+	return nil
+}
+
+func (s *MemStub) TxID() string {
+	return s.txID
+}
+
+func (s *MemStub) Invocation() *Invocation {
+	return s.invocation
+}
+
+func (s *MemStub) TxTime() (time.Time, error) {
+	if s.Time.IsZero() {
+		return time.Time{}, errors.New("Failed to get transaction timestamp")
+	}
+	return s.Time, nil
+}
+
+func (s *MemStub) GetTransient() (map[string][]byte, error) {
+	return s.Transient, nil
+}
+
+func (s *MemStub) ReadCertAttribute(attributeName string) ([]byte, error) {
+	value, found := s.Attributes[attributeName]
+	if !found {
+		return nil, fmt.Errorf("Attribute %s not found.", attributeName)
+	}
+	return []byte(value), nil
+}
+
+func (s *MemStub) VerifyAttribute(attributeName string, attributeValue []byte) (bool, error) {
+	value, err := s.ReadCertAttribute(attributeName)
+	if err != nil {
+		return false, err
+	}
+	return string(value) == string(attributeValue), nil
+}
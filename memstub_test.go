@@ -0,0 +1,144 @@
+package main
+
+import "testing"
+
+func newTestTable(t *testing.T) *MemStub {
+	s := NewMemStub()
+	err := s.CreateTable("T", []*ColumnDefinition{
+		&ColumnDefinition{Name: "A", Type: ColumnDefinition_STRING, Key: true},
+		&ColumnDefinition{Name: "B", Type: ColumnDefinition_STRING, Key: true},
+		&ColumnDefinition{Name: "V", Type: ColumnDefinition_STRING, Key: false},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+func testRow(values ...string) Row {
+	var columns []*Column
+	for _, v := range values {
+		columns = append(columns, &Column{Value: &Column_String_{String_: v}})
+	}
+	return Row{Columns: columns}
+}
+
+func TestMemStubRows(t *testing.T) {
+	s := newTestTable(t)
+
+	for _, r := range [][]string{{"b", "1", "x"}, {"a", "2", "y"}, {"a", "1", "z"}, {"ab", "1", "w"}} {
+		if ok, err := s.InsertRow("T", testRow(r...)); !ok || err != nil {
+			t.Fatalf("insert %v: %v %v", r, ok, err)
+		}
+	}
+	if ok, _ := s.InsertRow("T", testRow("a", "1", "again")); ok {
+		t.Fatal("inserted a duplicate key")
+	}
+	if ok, _ := s.ReplaceRow("T", testRow("c", "1", "none")); ok {
+		t.Fatal("replaced a missing row")
+	}
+	if ok, _ := s.ReplaceRow("T", testRow("a", "1", "zz")); !ok {
+		t.Fatal("failed to replace a row")
+	}
+	if _, err := s.InsertRow("T", testRow("a")); err == nil {
+		t.Fatal("inserted a row with missing columns")
+	}
+
+	row, _ := s.GetRow("T", keyColumns("a", "1"))
+	if row.Columns[2].GetString_() != "zz" {
+		t.Fatalf("unexpected row %v", row)
+	}
+	if row, _ := s.GetRow("T", keyColumns("c", "1")); len(row.Columns) != 0 {
+		t.Fatalf("expected an empty row, got %v", row)
+	}
+
+	// A partial key selects whole key columns only, in key order
+	rows, _ := s.GetRows("T", keyColumns("a"))
+	var got []string
+	for row := range rows {
+		got = append(got, row.Columns[1].GetString_())
+	}
+	if len(got) != 2 || got[0] != "1" || got[1] != "2" {
+		t.Fatalf("unexpected rows %v", got)
+	}
+
+	s.DeleteRow("T", keyColumns("a", "1"))
+	if row, _ := s.GetRow("T", keyColumns("a", "1")); len(row.Columns) != 0 {
+		t.Fatal("row not deleted")
+	}
+}
+
+func TestMemStubRollback(t *testing.T) {
+	s := newTestTable(t)
+	s.PutState("k", []byte("committed"))
+
+	_, err := s.Transaction("tx1", func() ([]byte, error) {
+		s.PutState("k", []byte("changed"))
+		s.InsertRow("T", testRow("a", "1", "x"))
+		s.CreateTable("U", []*ColumnDefinition{&ColumnDefinition{Name: "K", Key: true}})
+		s.SetEvent("e", []byte("{}"))
+		s.Invocation().Function = "f"
+		return nil, argCount("0")
+	})
+	expectCode(t, err, CodeInvalidArgument)
+
+	if v, _ := s.GetState("k"); string(v) != "committed" {
+		t.Fatalf("state not rolled back: %s", v)
+	}
+	if row, _ := s.GetRow("T", keyColumns("a", "1")); len(row.Columns) != 0 {
+		t.Fatal("row not rolled back")
+	}
+	if _, err := s.GetTable("U"); err == nil {
+		t.Fatal("table not rolled back")
+	}
+	if len(s.Events) != 0 {
+		t.Fatal("event of a failed transaction published")
+	}
+
+	s.Transaction("tx2", func() ([]byte, error) {
+		if s.Invocation().Function != "" {
+			t.Fatal("invocation of a previous transaction kept")
+		}
+		s.SetEvent("first", nil)
+		s.SetEvent("last", nil)
+		return nil, s.PutState("k", []byte("changed"))
+	})
+	if v, _ := s.GetState("k"); string(v) != "changed" {
+		t.Fatalf("state not committed: %s", v)
+	}
+	if len(s.Events) != 1 || s.Events[0].Name != "last" || s.Events[0].TxID != "tx2" {
+		t.Fatalf("unexpected events %+v", s.Events)
+	}
+}
+
+func TestMemStubReadsCommittedState(t *testing.T) {
+	s := newTestTable(t)
+	s.PutState("k", []byte("committed"))
+
+	s.Transaction("tx1", func() ([]byte, error) {
+		s.PutState("k", []byte("changed"))
+		s.PutState("n", []byte("new"))
+		s.InsertRow("T", testRow("a", "1", "x"))
+		if v, _ := s.GetState("k"); string(v) != "committed" {
+			t.Fatalf("read an uncommitted write: %s", v)
+		}
+		if v, _ := s.GetState("n"); v != nil {
+			t.Fatalf("read an uncommitted key: %s", v)
+		}
+		rows, _ := s.GetRows("T", keyColumns("a"))
+		for range rows {
+			t.Fatal("listed an uncommitted row")
+		}
+		return nil, s.DelState("k")
+	})
+
+	if v, _ := s.GetState("k"); v != nil {
+		t.Fatalf("delete not committed: %s", v)
+	}
+	if v, _ := s.GetState("n"); string(v) != "new" {
+		t.Fatalf("write not committed: %s", v)
+	}
+	if row, _ := s.GetRow("T", keyColumns("a", "1")); len(row.Columns) == 0 {
+		t.Fatal("row not committed")
+	}
+}
@@ -0,0 +1,360 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"regexp"
+	"sort"
+)
+
+// Organisations is the registry of the legal entities acting as parties. The Id of an organisation is the
+// name used in the Requester, Approver, Owner and Issuer columns and in the party attribute of certificates.
+type Organisations struct {
+}
+
+// Organisation is the registry entry of a legal entity
+type Organisation struct {
+	Id      string `json:"id"` //Party name, e.g. "BankA"
+	Name    string `json:"name"`
+	Type    string `json:"type"`    //One of the OrganisationType* constants
+	BIC     string `json:"bic"`     //ISO 9362 business identifier code, required for banks
+	LEI     string `json:"lei"`     //ISO 17442 legal entity identifier, optional
+	Country string `json:"country"` //ISO 3166-1 alpha-2 code
+	Status  string `json:"status"`  //OrganisationStatusActive or OrganisationStatusInactive
+}
+
+// OrganisationUpdate holds the fields update_organisation can change. Missing fields are left unchanged.
+type OrganisationUpdate struct {
+	Name    *string `json:"name"`
+	Type    *string `json:"type"`
+	BIC     *string `json:"bic"`
+	LEI     *string `json:"lei"`
+	Country *string `json:"country"`
+	Status  *string `json:"status"`
+}
+
+const (
+	OrganisationTypeBank       = "bank"
+	OrganisationTypeCorporate  = "corporate"
+	OrganisationTypeGovernment = "government"
+)
+
+var organisationTypes = []string{OrganisationTypeBank, OrganisationTypeCorporate, OrganisationTypeGovernment}
+
+// Only active organisations can be named as a party of new requests and documents
+const (
+	OrganisationStatusActive   = "active"
+	OrganisationStatusInactive = "inactive"
+)
+
+var organisationStatuses = []string{OrganisationStatusActive, OrganisationStatusInactive}
+
+// Types of organisation each side of a request or document can be. The applicant is the Requester and Owner,
+// the bank is the Approver and Issuer.
+var (
+	applicantTypes = []string{OrganisationTypeCorporate, OrganisationTypeGovernment}
+	bankTypes      = []string{OrganisationTypeBank}
+)
+
+var (
+	bicPattern     = regexp.MustCompile(`^[A-Z]{4}[A-Z]{2}[A-Z0-9]{2}([A-Z0-9]{3})?$`)
+	leiPattern     = regexp.MustCompile(`^[A-Z0-9]{18}[0-9]{2}$`)
+	countryPattern = regexp.MustCompile(`^[A-Z]{2}$`)
+)
+
+// Init creates the registry
+func (t *Organisations) Init(stub Stub, function string, args []string) ([]byte, error) {
+
+	_, err := stub.GetTable("OrganisationTable")
+	if err == nil {
+		// Table already exists; do not recreate
+		return nil, nil
+	}
+
+	err = stub.CreateTable("OrganisationTable", []*ColumnDefinition{
+		&ColumnDefinition{Name: "Id", Type: ColumnDefinition_STRING, Key: true},
+		&ColumnDefinition{Name: "Organisation", Type: ColumnDefinition_BYTES, Key: false},
+	})
+	if err != nil {
+		return nil, internalError(ErrorDetails{"table": "OrganisationTable"}, "Failed creating OrganisationTable.")
+	}
+	return nil, nil
+}
+
+func organisationRow(o Organisation) (Row, error) {
+	orgAsBytes, err := json.Marshal(o)
+	if err != nil {
+		return Row{}, internalError(ErrorDetails{"kind": AuditKindOrganisation, "id": o.Id}, "Error marshalling organisation %s", o.Id)
+	}
+	return Row{
+		Columns: []*Column{
+			&Column{Value: &Column_String_{String_: o.Id}},
+			&Column{Value: &Column_Bytes{Bytes: orgAsBytes}}},
+	}, nil
+}
+
+// RegisterOrganisation () – adds an organisation, active unless its status says otherwise
+func (t *Organisations) RegisterOrganisation(stub Stub, args []string) ([]byte, error) {
+
+	//Args
+	//			0
+	//		organisation JSON object (as string)
+	if len(args) != 1 {
+		return nil, argCount("1")
+	}
+
+	var o Organisation
+	dec := json.NewDecoder(bytes.NewReader([]byte(args[0])))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&o); err != nil {
+		return nil, invalidArgument(ErrorDetails{"field": "organisation"}, "Invalid organisation: %s", err.Error())
+	}
+	if o.Status == "" {
+		o.Status = OrganisationStatusActive
+	}
+	if err := validate_organisation(o); err != nil {
+		return nil, err
+	}
+
+	row, err := organisationRow(o)
+	if err != nil {
+		return nil, err
+	}
+	ok, err := stub.InsertRow("OrganisationTable", row)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return nil, alreadyExists(ErrorDetails{"kind": AuditKindOrganisation, "id": o.Id}, "Organisation %s already exists.", o.Id)
+	}
+
+	return nil, audit(stub, AuditKindOrganisation, o.Id, []string{o.Id}, "", o.Status)
+}
+
+// UpdateOrganisation () – changes the fields of an organisation other than its Id
+func (t *Organisations) UpdateOrganisation(stub Stub, args []string) ([]byte, error) {
+
+	//Args
+	//			0		1
+	//		  id		update JSON object (as string), see OrganisationUpdate
+	if len(args) != 2 {
+		return nil, argCount("2")
+	}
+
+	var u OrganisationUpdate
+	dec := json.NewDecoder(bytes.NewReader([]byte(args[1])))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&u); err != nil {
+		return nil, invalidArgument(ErrorDetails{"field": "update"}, "Invalid organisation update: %s", err.Error())
+	}
+
+	o, err := get_organisation(stub, args[0])
+	if err != nil {
+		return nil, err
+	}
+	oldStatus := o.Status
+	if u.Name != nil {
+		o.Name = *u.Name
+	}
+	if u.Type != nil {
+		o.Type = *u.Type
+	}
+	if u.BIC != nil {
+		o.BIC = *u.BIC
+	}
+	if u.LEI != nil {
+		o.LEI = *u.LEI
+	}
+	if u.Country != nil {
+		o.Country = *u.Country
+	}
+	if u.Status != nil {
+		o.Status = *u.Status
+	}
+	if err := validate_organisation(o); err != nil {
+		return nil, err
+	}
+
+	row, err := organisationRow(o)
+	if err != nil {
+		return nil, err
+	}
+	ok, err := stub.ReplaceRow("OrganisationTable", row)
+	if !ok && err == nil {
+		return nil, notFound(ErrorDetails{"kind": AuditKindOrganisation, "id": o.Id}, "Unknown organisation %s.", o.Id)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return nil, audit(stub, AuditKindOrganisation, o.Id, []string{o.Id}, oldStatus, o.Status)
+}
+
+// GetOrganisation () – returns an organisation
+func (t *Organisations) GetOrganisation(stub Stub, args []string) ([]byte, error) {
+
+	if len(args) != 1 {
+		return nil, argCount("1")
+	}
+	o, err := get_organisation(stub, args[0])
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(o)
+}
+
+// GetOrganisations () – lists the organisations by Id, filtered by status and type
+func (t *Organisations) GetOrganisations(stub Stub, args []string) ([]byte, error) {
+
+	if len(args) > 1 {
+		return nil, argCount("0 or 1")
+	}
+	o, err := listOptionsArg(args, 0)
+	if err != nil {
+		return nil, err
+	}
+	if o.CreatedFrom != "" || o.CreatedTo != "" || o.DocumentType != "" || o.Organisation != "" || o.Role != "" {
+		return nil, invalidArgument(ErrorDetails{"field": "options"}, "Invalid list options: organisations can only be filtered by status and type")
+	}
+
+	// An empty key selects every row of the table
+	rows, err := stub.GetRows("OrganisationTable", keyColumns())
+	if err != nil {
+		return nil, internalError(ErrorDetails{"table": "OrganisationTable"}, "Failed to retrieve rows")
+	}
+
+	organisations := []Organisation{}
+	for row := range rows {
+		if len(row.Columns) == 0 {
+			continue
+		}
+		var org Organisation
+		err := json.Unmarshal(row.Columns[1].GetBytes(), &org)
+		if err != nil {
+			return nil, internalError(ErrorDetails{"kind": AuditKindOrganisation, "id": row.Columns[0].GetString_()}, "Error unmarshalling organisation %s", row.Columns[0].GetString_())
+		}
+		if (o.Status == "" || o.Status == org.Status) && (o.Type == "" || o.Type == org.Type) {
+			organisations = append(organisations, org)
+		}
+	}
+
+	sort.Slice(organisations, func(i, j int) bool {
+		return organisations[i].Id < organisations[j].Id
+	})
+
+	keys := make([]listKey, len(organisations))
+	for i, org := range organisations {
+		keys[i] = listKey{org.Id}
+	}
+	first, last, next, err := paginate(keys, o, false)
+	if err != nil {
+		return nil, err
+	}
+
+	page := organisations[first:last]
+	return json.Marshal(ListResponse{Count: len(page), Data: page, NextCursor: next})
+}
+
+// get_organisation returns the registry entry of an organisation
+func get_organisation(stub Stub, id string) (Organisation, error) {
+
+	var o Organisation
+
+	row, err := stub.GetRow("OrganisationTable", keyColumns(id))
+	if err != nil {
+		return o, internalError(ErrorDetails{"kind": AuditKindOrganisation, "id": id}, "Error: Failed retrieving organisation %s. Error %s", id, err.Error())
+	}
+	if len(row.Columns) == 0 {
+		return o, notFound(ErrorDetails{"kind": AuditKindOrganisation, "id": id}, "Unknown organisation %s.", id)
+	}
+
+	err = json.Unmarshal(row.Columns[1].GetBytes(), &o)
+	if err != nil {
+		return o, internalError(ErrorDetails{"kind": AuditKindOrganisation, "id": id}, "Error unmarshalling organisation %s", id)
+	}
+	return o, nil
+}
+
+// check_organisation returns an error unless party is an active organisation of one of the given types
+func check_organisation(stub Stub, party string, types ...string) error {
+
+	o, err := get_organisation(stub, party)
+	if err != nil {
+		return err
+	}
+	if !contains(types, o.Type) {
+		return invalidArgument(ErrorDetails{"party": party, "type": o.Type, "expecting": types}, "Organisation %s is a %s organisation, it can not be named here.", party, o.Type)
+	}
+	if o.Status != OrganisationStatusActive {
+		return conflict(ErrorDetails{"party": party, "status": o.Status}, "Organisation %s is %s.", party, o.Status)
+	}
+	return nil
+}
+
+// check_parties checks the parties of a request or document: the applicant must be a corporate or government
+// organisation and the bank a bank, both active. The beneficiary named in dataJSON, if any, must be an active
+// organisation of any type.
+func check_parties(stub Stub, applicant string, bank string, dataJSON []byte) error {
+	if err := check_organisation(stub, applicant, applicantTypes...); err != nil {
+		return err
+	}
+	if err := check_organisation(stub, bank, bankTypes...); err != nil {
+		return err
+	}
+	if terms, _ := parseTerms(dataJSON); terms.Beneficiary != "" {
+		return check_organisation(stub, terms.Beneficiary, organisationTypes...)
+	}
+	return nil
+}
+
+// validate_organisation checks the fields of an organisation
+func validate_organisation(o Organisation) error {
+
+	if o.Id == "" {
+		return invalidArgument(ErrorDetails{"field": "id"}, "Invalid organisation: id is required")
+	}
+	if o.Name == "" {
+		return invalidArgument(ErrorDetails{"field": "name"}, "Invalid organisation %s: name is required", o.Id)
+	}
+	if !contains(organisationTypes, o.Type) {
+		return invalidArgument(ErrorDetails{"field": "type", "expecting": organisationTypes}, "Invalid organisation %s: unknown type %s", o.Id, o.Type)
+	}
+	if !contains(organisationStatuses, o.Status) {
+		return invalidArgument(ErrorDetails{"field": "status", "expecting": organisationStatuses}, "Invalid organisation %s: unknown status %s", o.Id, o.Status)
+	}
+	if !countryPattern.MatchString(o.Country) {
+		return invalidArgument(ErrorDetails{"field": "country"}, "Invalid organisation %s: country must be an ISO 3166-1 alpha-2 code", o.Id)
+	}
+
+	// The fifth and sixth characters of a BIC are the country of the institution
+	if o.BIC == "" && o.Type == OrganisationTypeBank {
+		return invalidArgument(ErrorDetails{"field": "bic"}, "Invalid organisation %s: banks require a BIC", o.Id)
+	}
+	if o.BIC != "" && (!bicPattern.MatchString(o.BIC) || o.BIC[4:6] != o.Country) {
+		return invalidArgument(ErrorDetails{"field": "bic"}, "Invalid organisation %s: %s is not a BIC of country %s", o.Id, o.BIC, o.Country)
+	}
+	if o.LEI != "" && !valid_lei(o.LEI) {
+		return invalidArgument(ErrorDetails{"field": "lei"}, "Invalid organisation %s: %s is not a valid LEI", o.Id, o.LEI)
+	}
+	return nil
+}
+
+// valid_lei checks the format and the check digits of an LEI. As in an IBAN, letters count as 10 to 35 and
+// the whole code read as a number must leave 1 when divided by 97 (ISO 7064 MOD 97-10).
+func valid_lei(lei string) bool {
+	if !leiPattern.MatchString(lei) {
+		return false
+	}
+	var digits bytes.Buffer
+	for _, c := range lei {
+		if c >= 'A' && c <= 'Z' {
+			fmt.Fprint(&digits, int(c-'A')+10)
+		} else {
+			digits.WriteRune(c)
+		}
+	}
+	n, ok := new(big.Int).SetString(digits.String(), 10)
+	return ok && new(big.Int).Mod(n, big.NewInt(97)).Int64() == 1
+}
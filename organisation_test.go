@@ -0,0 +1,137 @@
+package main
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestRegisterOrganisation(t *testing.T) {
+	h := newTestChaincode(t)
+	h.admin().mustInvoke("register_organisation", `{"id": "MinistryF", "name": "Ministry F", "type": "government", "country": "FR"}`)
+
+	var o Organisation
+	h.beneficiary().mustQueryInto(&o, "get_organisation", "MinistryF")
+	if o.Type != OrganisationTypeGovernment || o.Status != OrganisationStatusActive {
+		t.Fatalf("unexpected organisation %+v", o)
+	}
+
+	for _, invalid := range []string{
+		`{"id": "BankG", "name": "Bank G", "type": "bank", "country": "DE"}`,
+		`{"id": "BankG", "name": "Bank G", "type": "bank", "bic": "BKGGFRPP", "country": "DE"}`,
+		`{"id": "BankG", "name": "Bank G", "type": "bank", "bic": "BKGG", "country": "DE"}`,
+		`{"id": "BankG", "name": "Bank G", "type": "bank", "bic": "BKGGDEFF", "country": "DE", "lei": "5493001KJTIIGC8Y1R13"}`,
+		`{"id": "BankG", "name": "Bank G", "type": "insurer", "country": "DE"}`,
+		`{"id": "BankG", "name": "Bank G", "type": "corporate", "country": "Germany"}`,
+		`{"id": "BankG", "type": "corporate", "country": "DE"}`,
+		`{"id": "BankG", "name": "Bank G", "type": "corporate", "country": "DE", "colour": "red"}`,
+	} {
+		_, err := h.admin().invoke("register_organisation", invalid)
+		expectCode(t, err, CodeInvalidArgument)
+	}
+
+	_, err := h.invoke("register_organisation", `{"id": "BankA", "name": "Bank A", "type": "bank", "bic": "BKAAGB2L", "country": "GB"}`)
+	expectCode(t, err, CodeAlreadyExists)
+	_, err = h.officer().invoke("register_organisation", `{"id": "BankG", "name": "Bank G", "type": "bank", "bic": "BKGGDEFF", "country": "DE"}`)
+	expectCode(t, err, CodeForbidden)
+	_, err = h.query("get_organisation", "BankG")
+	expectCode(t, err, CodeNotFound)
+}
+
+func TestUpdateOrganisation(t *testing.T) {
+	h := newTestChaincode(t)
+
+	h.admin().mustInvoke("update_organisation", "CorpB", `{"name": "Corp B plc"}`)
+	var o Organisation
+	h.mustQueryInto(&o, "get_organisation", "CorpB")
+	if o.Name != "Corp B plc" || o.LEI == "" {
+		t.Fatalf("unexpected organisation %+v", o)
+	}
+
+	_, err := h.invoke("update_organisation", "BankA", `{"bic": ""}`)
+	expectCode(t, err, CodeInvalidArgument)
+	_, err = h.invoke("update_organisation", "BankA", `{"id": "BankB"}`)
+	expectCode(t, err, CodeInvalidArgument)
+	_, err = h.invoke("update_organisation", "BankZ", `{"name": "Bank Z"}`)
+	expectCode(t, err, CodeNotFound)
+}
+
+func TestListOrganisations(t *testing.T) {
+	h := newTestChaincode(t)
+	h.admin().mustInvoke("update_organisation", "SupplierC", `{"status": "inactive"}`)
+
+	var page listOf
+	var organisations []Organisation
+	h.applicant().mustQueryInto(&page, "list_organisations", `{"pageSize": 2}`)
+	json.Unmarshal(page.Data, &organisations)
+	if page.Count != 2 || organisations[0].Id != "BankA" || organisations[1].Id != "CorpB" || page.NextCursor == "" {
+		t.Fatalf("unexpected first page %+v", page)
+	}
+
+	h.mustQueryInto(&page, "list_organisations", `{"type": "corporate", "status": "active"}`)
+	json.Unmarshal(page.Data, &organisations)
+	if page.Count != 1 || organisations[0].Id != "CorpB" {
+		t.Fatalf("unexpected active corporates %+v", organisations)
+	}
+
+	_, err := h.query("list_organisations", `{"role": "admin"}`)
+	expectCode(t, err, CodeInvalidArgument)
+}
+
+func TestPartiesMustBeOrganisations(t *testing.T) {
+	h := newTestChaincode(t)
+
+	// The approver must be a bank and the requester must not
+	_, err := h.applicant().invoke("submit_new_request", RequestTypeNew, "CorpB", "SupplierC", "R1", lgDocJSON(1000), StatusSubmitted, "{}")
+	expectCode(t, err, CodeInvalidArgument)
+	_, err = h.as("eve", RoleApplicant, "BankA").invoke("submit_new_request", RequestTypeNew, "BankA", "BankA", "R1", lgDocJSON(1000), StatusSubmitted, "{}")
+	expectCode(t, err, CodeInvalidArgument)
+	_, err = h.applicant().invoke("submit_new_request", RequestTypeNew, "CorpB", "BankQ", "R1", lgDocJSON(1000), StatusSubmitted, "{}")
+	expectCode(t, err, CodeNotFound)
+	_, err = h.as("eve", RoleBankOfficer, "CorpB").invoke("issue_document", "SupplierC", "CorpB", "LG", "D1", `{"amount": 1}`, DocStatusIssued, "{}", testExpiryDate)
+	expectCode(t, err, CodeInvalidArgument)
+	h.officer().mustInvoke("issue_document", "SupplierC", "BankA", "LG", "D1", `{"amount": 1}`, DocStatusIssued, "{}", testExpiryDate)
+
+	// Requests to an inactive bank are refused, and so is their approval
+	h.submitRequest("R1", 1000)
+	h.admin().mustInvoke("update_organisation", "BankA", `{"status": "inactive"}`)
+	_, err = h.applicant().invoke("submit_new_request", RequestTypeNew, "CorpB", "BankA", "R2", lgDocJSON(1000), StatusSubmitted, "{}")
+	expectCode(t, err, CodeConflict)
+	_, err = h.officer().invoke("approve_new_request", "CorpB", "BankA", "R1")
+	expectCode(t, err, CodeConflict)
+
+	h.admin().mustInvoke("update_organisation", "BankA", `{"status": "active"}`)
+	h.officer().mustInvoke("approve_new_request", "CorpB", "BankA", "R1")
+
+	// The beneficiary named in the DocJSON must be registered and active, also to claim
+	_, err = h.applicant().invoke("submit_new_request", RequestTypeNew, "CorpB", "BankA", "R2", `{"amount": 1, "beneficiary": "SupplierQ"}`, StatusSubmitted, "{}")
+	expectCode(t, err, CodeNotFound)
+	h.admin().mustInvoke("update_organisation", "SupplierC", `{"status": "inactive"}`)
+	_, err = h.applicant().invoke("submit_new_request", RequestTypeNew, "CorpB", "BankA", "R2", lgDocJSON(1000), StatusSubmitted, "{}")
+	expectCode(t, err, CodeConflict)
+	_, err = h.beneficiary().invoke("submit_claim", "CorpB", "BankA", "R1", "C1", "1", "Unpaid invoice")
+	expectCode(t, err, CodeConflict)
+}
+
+func TestUserOrganisation(t *testing.T) {
+	h := newTestChaincode(t)
+
+	var u User
+	json.Unmarshal([]byte(testUser("alice")), &u)
+	u.Organisation = "CorpQ"
+	userAsBytes, _ := json.Marshal(u)
+	_, err := h.admin().invoke("add_user", "alice", string(userAsBytes))
+	expectCode(t, err, CodeNotFound)
+
+	u.Organisation = "CorpB"
+	userAsBytes, _ = json.Marshal(u)
+	h.mustInvoke("add_user", "alice", string(userAsBytes))
+
+	// A user can only act for the organisation it is registered under
+	h.submitRequest("R1", 1000)
+	_, err = h.as("alice", RoleApplicant, "CorpD").invoke("submit_new_request", RequestTypeNew, "CorpD", "BankA", "R2", lgDocJSON(1000), StatusSubmitted, "{}")
+	expectCode(t, err, CodeForbidden)
+	if !strings.Contains(err.Error(), "registered under CorpB") {
+		t.Fatalf("unexpected error %s", err)
+	}
+}
@@ -1,21 +1,159 @@
 package main
 
 import (
-	//"encoding/json"
-
+	"encoding/json"
 	"errors"
 	"fmt"
-
-	"time"
-	"strconv"
-	"github.com/hyperledger/fabric/core/chaincode/shim"
+	"sort"
 )
 
 type Request struct {
 }
 
+// RequestRecord is a row of the RequestTable
+type RequestRecord struct {
+	RequestType string          `json:"requestType"`
+	Requester   string          `json:"requester"`
+	Approver    string          `json:"approver"`
+	Uid         string          `json:"uid"`
+	DocJSON     json.RawMessage `json:"data"`
+	Status      string          `json:"status"`
+	Permissions json.RawMessage `json:"permissions"`
+	CreatedAt   string          `json:"createdAt"`
+	Reason      string          `json:"reason"`
+	DocumentUid string          `json:"documentUid"`
+}
+
+// requestFromRow converts a RequestTable row to a RequestRecord
+func requestFromRow(row Row) RequestRecord {
+	return RequestRecord{
+		RequestType: row.Columns[0].GetString_(),
+		Requester:   row.Columns[1].GetString_(),
+		Approver:    row.Columns[2].GetString_(),
+		Uid:         row.Columns[3].GetString_(),
+		DocJSON:     rawJSON(row.Columns[4].GetBytes()),
+		Status:      row.Columns[5].GetString_(),
+		Permissions: rawJSON(row.Columns[6].GetBytes()),
+		CreatedAt:   row.Columns[7].GetString_(),
+		Reason:      row.Columns[8].GetString_(),
+		DocumentUid: row.Columns[9].GetString_(),
+	}
+}
+
+// toRow converts a RequestRecord to a RequestTable row
+func (r RequestRecord) toRow() Row {
+	return Row{
+		Columns: []*Column{
+			&Column{Value: &Column_String_{String_: r.RequestType}},
+			&Column{Value: &Column_String_{String_: r.Requester}},
+			&Column{Value: &Column_String_{String_: r.Approver}},
+			&Column{Value: &Column_String_{String_: r.Uid}},
+			&Column{Value: &Column_Bytes{Bytes: r.DocJSON}},
+			&Column{Value: &Column_String_{String_: r.Status}},
+			&Column{Value: &Column_Bytes{Bytes: r.Permissions}},
+			&Column{Value: &Column_String_{String_: r.CreatedAt}},
+			&Column{Value: &Column_String_{String_: r.Reason}},
+			&Column{Value: &Column_String_{String_: r.DocumentUid}}},
+	}
+}
+
+// Request types. A "new" request asks for a document to be issued, an "amendment"
+// asks for a new version of an issued document.
+const (
+	RequestTypeNew       = "new"
+	RequestTypeAmendment = "amendment"
+)
+
+// requestDoc holds the fields of a request's DocJSON used to issue the document on approval
+type requestDoc struct {
+	DocumentType string `json:"documentType"`
+	DocumentUid  string `json:"documentUid"`
+	PreviousUid  string `json:"previousUid"`
+	ExpiryDate   string `json:"expiryDate"`
+}
+
+// documentType returns the type of document the request is for
+func (r RequestRecord) documentType() string {
+	var doc requestDoc
+	json.Unmarshal(r.DocJSON, &doc)
+	if doc.DocumentType == "" {
+		return "LG"
+	}
+	return doc.DocumentType
+}
+
+// checkDocumentType checks the request against the document type registry. The required fields
+// are only checked once the request is submitted, drafts may be incomplete.
+func (r RequestRecord) checkDocumentType(stub Stub) error {
+	op := OpIssue
+	if r.RequestType == RequestTypeAmendment {
+		op = OpAmend
+	}
+	if r.Status == StatusDraft {
+		return check_document_operation(stub, r.documentType(), op, "", nil)
+	}
+	return check_document_operation(stub, r.documentType(), op, "DocJSON", r.DocJSON)
+}
+
+// ApprovalResult is returned by approve_new_request with the document issued or amended
+type ApprovalResult struct {
+	RequestUid  string `json:"requestUid"`
+	DocumentUid string `json:"documentUid"`
+}
+
+// requestTypeArg returns the optional request type passed after the n mandatory arguments
+func requestTypeArg(args []string, n int) string {
+	if len(args) > n && args[n] != "" {
+		return args[n]
+	}
+	return RequestTypeNew
+}
+
+// Request statuses. A request moves through them as follows:
+//
+//	draft -> submitted -> under_review -> approved | rejected | returned
+//	returned -> submitted
+//
+// and can be withdrawn by the requester until a decision has been taken.
+const (
+	StatusDraft       = "draft"
+	StatusSubmitted   = "submitted"
+	StatusUnderReview = "under_review"
+	StatusApproved    = "approved"
+	StatusRejected    = "rejected"
+	StatusReturned    = "returned"
+	StatusWithdrawn   = "withdrawn"
+)
+
+// requestTransitions lists the statuses a request can move to from a given status.
+// Approved, rejected and withdrawn are final.
+var requestTransitions = map[string][]string{
+	StatusDraft:       {StatusSubmitted, StatusWithdrawn},
+	StatusSubmitted:   {StatusUnderReview, StatusApproved, StatusRejected, StatusReturned, StatusWithdrawn},
+	StatusUnderReview: {StatusApproved, StatusRejected, StatusReturned, StatusWithdrawn},
+	StatusReturned:    {StatusSubmitted, StatusWithdrawn},
+}
+
+// checkTransition returns an error if a request can not move from status "from" to status "to"
+func checkTransition(from string, to string) error {
+	for _, s := range requestTransitions[from] {
+		if s == to {
+			return nil
+		}
+	}
+	return invalidTransition(ErrorDetails{"from": from, "to": to}, "Invalid transition: request can not move from %s to %s", from, to)
+}
+
 //Init initializes the request model/smart contract
-func (t *Request) Init(stub *shim.ChaincodeStub, function string, args []string) ([]byte, error) {
+func (t *Request) Init(stub Stub, function string, args []string) ([]byte, error) {
+	// The UID index is created on its own, deployments that predate it already have the table
+	if err := create_uid_index(stub, requestUidIndex); err != nil {
+		return nil, err
+	}
+	if err := create_approver_index(stub); err != nil {
+		return nil, err
+	}
+
 	// Check if table already exists
 	_, err := stub.GetTable("RequestTable")
 	if err == nil {
@@ -25,15 +163,17 @@ func (t *Request) Init(stub *shim.ChaincodeStub, function string, args []string)
 
 
 	// Create Request Table
-	err = stub.CreateTable("RequestTable", []*shim.ColumnDefinition{
-		&shim.ColumnDefinition{Name: "RequestType", Type: shim.ColumnDefinition_STRING, Key: true},
-		&shim.ColumnDefinition{Name: "Requester", Type: shim.ColumnDefinition_STRING, Key: true},
-		&shim.ColumnDefinition{Name: "Approver", Type: shim.ColumnDefinition_STRING, Key: true},
-		&shim.ColumnDefinition{Name: "Uid", Type: shim.ColumnDefinition_STRING, Key: true},
-		&shim.ColumnDefinition{Name: "DocJSON", Type: shim.ColumnDefinition_BYTES, Key: false},
-		&shim.ColumnDefinition{Name: "Status", Type: shim.ColumnDefinition_STRING, Key: false},
-		&shim.ColumnDefinition{Name: "Permissions", Type: shim.ColumnDefinition_BYTES, Key: false},
-		&shim.ColumnDefinition{Name: "CreatedAt", Type: shim.ColumnDefinition_STRING, Key: false},
+	err = stub.CreateTable("RequestTable", []*ColumnDefinition{
+		&ColumnDefinition{Name: "RequestType", Type: ColumnDefinition_STRING, Key: true},
+		&ColumnDefinition{Name: "Requester", Type: ColumnDefinition_STRING, Key: true},
+		&ColumnDefinition{Name: "Approver", Type: ColumnDefinition_STRING, Key: true},
+		&ColumnDefinition{Name: "Uid", Type: ColumnDefinition_STRING, Key: true},
+		&ColumnDefinition{Name: "DocJSON", Type: ColumnDefinition_BYTES, Key: false},
+		&ColumnDefinition{Name: "Status", Type: ColumnDefinition_STRING, Key: false},
+		&ColumnDefinition{Name: "Permissions", Type: ColumnDefinition_BYTES, Key: false},
+		&ColumnDefinition{Name: "CreatedAt", Type: ColumnDefinition_STRING, Key: false},
+		&ColumnDefinition{Name: "StatusReason", Type: ColumnDefinition_STRING, Key: false},
+		&ColumnDefinition{Name: "DocumentUid", Type: ColumnDefinition_STRING, Key: false},
 	})
 	if err != nil {
 		return nil, errors.New("Failed creating Request Table.")
@@ -44,10 +184,10 @@ func (t *Request) Init(stub *shim.ChaincodeStub, function string, args []string)
 }
 
 //SubmitDoc () – Calls ValidateDoc internally and upon success inserts a new row in the table
-func (t *Request) SubmitNewRequest(stub *shim.ChaincodeStub, args []string) ([]byte, error) {
+func (t *Request) SubmitNewRequest(stub Stub, args []string) ([]byte, error) {
 
 	if len(args) != 7 {
-		return nil, errors.New("Incorrect number of arguments. Expecting 6.")
+		return nil, argCount("7")
 	}
 	requestType := args[0]
 	requester := args[1]
@@ -57,176 +197,722 @@ func (t *Request) SubmitNewRequest(stub *shim.ChaincodeStub, args []string) ([]b
 	status := args[5]
 	permissions := []byte(args[6])
 
-	//TODO: Validate input
+	// Amendments are submitted through amend_lg_document
+	if requestType != RequestTypeNew {
+		return nil, invalidArgument(ErrorDetails{"field": "requestType", "expecting": RequestTypeNew}, "Invalid request type %s. Expecting %s.", requestType, RequestTypeNew)
+	}
+
+	// New requests start either as a draft or submitted for approval
+	if status == "" {
+		status = StatusSubmitted
+	}
+	if status != StatusDraft && status != StatusSubmitted {
+		return nil, invalidArgument(ErrorDetails{"field": "status", "expecting": []string{StatusDraft, StatusSubmitted}}, "Invalid status %s. Expecting %s or %s.", status, StatusDraft, StatusSubmitted)
+	}
+
+	// Only the requester can submit on its own behalf
+	if err := check_party(stub, requester); err != nil {
+		return nil, err
+	}
+	if err := check_parties(stub, requester, approver, docJSON); err != nil {
+		return nil, err
+	}
+
+	if err := checkJSON("DocJSON", docJSON); err != nil {
+		return nil, err
+	}
+	if err := checkPermissions(permissions); err != nil {
+		return nil, err
+	}
+
+	// A request submitted without a UID gets one allocated
+	if UID == "" {
+		UID = new_id(stub, "R")
+	}
+	r := RequestRecord{
+		RequestType: requestType,
+		Requester:   requester,
+		Approver:    approver,
+		Uid:         UID,
+		DocJSON:     docJSON,
+		Status:      status,
+		Permissions: permissions,
+	}
+	if err := r.checkDocumentType(stub); err != nil {
+		return nil, err
+	}
+
+	if err := t.insert(stub, r); err != nil {
+		return nil, err
+	}
+	return json.Marshal(CreatedResponse{Uid: UID})
+}
+
+// SubmitAmendment () – the owner of an issued document asks the issuer for a new version of it.
+// The DocJSON names the version being amended in "previousUid" and the new version in "documentUid".
+func (t *Request) SubmitAmendment(stub Stub, args []string) ([]byte, error) {
+
+	if len(args) != 5 {
+		return nil, argCount("5")
+	}
+	requester := args[0]
+	approver := args[1]
+	uid := args[2]
+	docJSON := []byte(args[3])
+	permissions := []byte(args[4])
+
+	// Only the owner of the document can ask for an amendment
+	if err := check_party(stub, requester); err != nil {
+		return nil, err
+	}
+	if err := check_parties(stub, requester, approver, docJSON); err != nil {
+		return nil, err
+	}
+
+	if err := checkPermissions(permissions); err != nil {
+		return nil, err
+	}
+	var doc requestDoc
+	err := json.Unmarshal(docJSON, &doc)
+	if err != nil {
+		return nil, invalidArgument(ErrorDetails{"field": "docJSON"}, "Invalid DocJSON: %s", err.Error())
+	}
+	if doc.PreviousUid == "" || doc.DocumentUid == "" {
+		return nil, invalidArgument(ErrorDetails{"field": "docJSON"}, "An amendment must name the previousUid and documentUid of the document.")
+	}
+	if doc.DocumentType == "" {
+		doc.DocumentType = "LG"
+	}
+	if err := check_document_operation(stub, doc.DocumentType, OpAmend, "DocJSON", docJSON); err != nil {
+		return nil, err
+	}
+
+	// The document must still be live
+	var document Document
+	previous, err := document.get(stub, requester, approver, doc.DocumentType, doc.PreviousUid)
+	if err != nil {
+		return nil, err
+	}
+	if err := document.checkLive(stub, previous, "amended"); err != nil {
+		return nil, err
+	}
+	// The document's Permissions can further restrict who may amend it
+	if err := check_permission(stub, previous.Permissions, RightAmend, requester); err != nil {
+		return nil, err
+	}
+
+	if uid == "" {
+		uid = new_id(stub, "R")
+	}
+	err = t.insert(stub, RequestRecord{
+		RequestType: RequestTypeAmendment,
+		Requester:   requester,
+		Approver:    approver,
+		Uid:         uid,
+		DocJSON:     docJSON,
+		Status:      StatusSubmitted,
+		Permissions: permissions,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(CreatedResponse{Uid: uid})
+}
+
+// insert adds a new request, stamped with the transaction time
+func (t *Request) insert(stub Stub, r RequestRecord) error {
 
 	//time
-	createdTime := time.Now()
+	createdTime, err := timestamp(stub)
+	if err != nil {
+		return err
+	}
+	r.CreatedAt = createdTime
+
+	// The UID must be unique across all requesters and approvers
+	err = put_uid_index(stub, requestUidIndex, r.Uid, r.RequestType, r.Requester, r.Approver)
+	if err != nil {
+		return err
+	}
 
 	// Insert a row
-	ok, err := stub.InsertRow("RequestTable", shim.Row{
-		Columns: []*shim.Column{
-			&shim.Column{Value: &shim.Column_String_{String_: requestType}},
-			&shim.Column{Value: &shim.Column_String_{String_: requester}},
-			&shim.Column{Value: &shim.Column_String_{String_: approver}},
-			&shim.Column{Value: &shim.Column_String_{String_: UID}},
-			&shim.Column{Value: &shim.Column_Bytes{Bytes: docJSON}},
-			&shim.Column{Value: &shim.Column_String_{String_: status}},
-			&shim.Column{Value: &shim.Column_Bytes{Bytes: permissions}},
-			&shim.Column{Value: &shim.Column_String_{String_: createdTime.Format(time.RFC3339)}}},
-	})
+	ok, err := stub.InsertRow("RequestTable", r.toRow())
 
 	if !ok && err == nil {
-		return nil, errors.New("Document already exists.")
+		return alreadyExists(ErrorDetails{"kind": AuditKindRequest, "uid": r.Uid}, "Request with uid %s already exists.", r.Uid)
 	}
+	if err != nil {
+		return err
+	}
+
+	err = put_approver_index(stub, r)
+	if err != nil {
+		return err
+	}
+	err = audit(stub, AuditKindRequest, r.Uid, []string{r.RequestType, r.Requester, r.Approver, r.Uid}, "", r.Status)
+	if err != nil {
+		return err
+	}
+	return t.emit(stub, r, "")
+}
 
-	return nil, err
+// replace overwrites an existing request
+func (t *Request) replace(stub Stub, r RequestRecord) error {
+
+	// Key columns never change, only requests submitted before the index existed need an entry
+	err := ensure_uid_index(stub, requestUidIndex, r.Uid, r.RequestType, r.Requester, r.Approver)
+	if err != nil {
+		return err
+	}
+
+	old, err := t.get(stub, r.RequestType, r.Requester, r.Approver, r.Uid)
+	if err != nil {
+		return err
+	}
+
+	ok, err := stub.ReplaceRow("RequestTable", r.toRow())
+
+	if !ok && err == nil {
+		return notFound(ErrorDetails{"kind": AuditKindRequest, "uid": r.Uid}, "Request with uid %s does not exist.", r.Uid)
+	}
+	if err != nil {
+		return err
+	}
+
+	err = put_approver_index(stub, r)
+	if err != nil {
+		return err
+	}
+	err = audit(stub, AuditKindRequest, r.Uid, []string{r.RequestType, r.Requester, r.Approver, r.Uid}, old.Status, r.Status)
+	if err != nil {
+		return err
+	}
+	if old.Status == r.Status {
+		return nil
+	}
+	return t.emit(stub, r, old.Status)
+}
+
+// emit publishes the event of a request that moved from oldStatus to its current status
+func (t *Request) emit(stub Stub, r RequestRecord, oldStatus string) error {
+	name := requestEvent(r.Status)
+	if name == "" {
+		return nil
+	}
+	return emit_event(stub, name, r.Uid, EventParties{Requester: r.Requester, Approver: r.Approver}, oldStatus, r.Status)
 }
 
 // GetRequestDocument () – returns as JSON a single document w.r.t. the UID
-func (t *Request) GetJSON(stub *shim.ChaincodeStub, args []string) ([]byte, error) {
+func (t *Request) GetJSON(stub Stub, args []string) ([]byte, error) {
 
-	if len(args) != 3 {
-		return nil, errors.New("Incorrect number of arguments. Expecting 3.")
+	if len(args) != 3 && len(args) != 4 {
+		return nil, argCount("3 or 4")
 	}
-	requestType := "new"
 	requester := args[0]
 	approver := args[1]
 	uid := args[2]
+	requestType := requestTypeArg(args, 3)
 
 	// Get the row pertaining to this UID
-	var columns []shim.Column
-	col1 := shim.Column{Value: &shim.Column_String_{String_: requestType}}
-	columns = append(columns, col1)
-	col2 := shim.Column{Value: &shim.Column_String_{String_: requester}}
-	columns = append(columns, col2)
-	col3 := shim.Column{Value: &shim.Column_String_{String_: approver}}
-	columns = append(columns, col3)
-	col4 := shim.Column{Value: &shim.Column_String_{String_: uid}}
-	columns = append(columns, col4)
-
-	row, err := stub.GetRow("RequestTable", columns)
+	row, err := stub.GetRow("RequestTable", keyColumns(requestType, requester, approver, uid))
 	if err != nil {
-		return nil, fmt.Errorf("Error: Failed retrieving document with uid %s. Error %s", uid, err.Error())
+		return nil, fmt.Errorf("Error: Failed retrieving request with uid %s. Error %s", uid, err.Error())
 	}
 
 	// GetRows returns empty message if key does not exist
 	if len(row.Columns) == 0 {
-		return nil, nil
+		// Only the parties of the request can learn that it does not exist
+		if err := check_party(stub, requester, approver); err != nil {
+			return nil, err
+		}
+		return nil, notFound(ErrorDetails{"kind": AuditKindRequest, "uid": uid}, "Request with uid %s does not exist.", uid)
+	}
+	logger.Debugf("UID " + row.Columns[3].GetString_())
+
+	// The request's Permissions decide who can read it, by default its parties
+	r := requestFromRow(row)
+	if err := check_permission(stub, r.Permissions, RightRead, requester, approver); err != nil {
+		return nil, err
+	}
+
+	return json.Marshal(r)
+
+}
+
+// GetRequestByUid () – returns as JSON a single request found by its UID alone
+func (t *Request) GetRequestByUid(stub Stub, args []string) ([]byte, error) {
+
+	if len(args) != 1 {
+		return nil, argCount("1")
+	}
+	uid := args[0]
+
+	key, found, err := get_uid_index(stub, requestUidIndex, uid)
+	if err != nil {
+		return nil, err
+	}
+	if !found {
+		// A UID names no parties, only auditors and admins can learn that it is not in use
+		if err := check_party(stub); err != nil {
+			return nil, err
+		}
+		return nil, notFound(ErrorDetails{"kind": AuditKindRequest, "uid": uid}, "Request with uid %s does not exist.", uid)
+	}
+
+	r, err := t.get(stub, key[0], key[1], key[2], uid)
+	if err != nil {
+		return nil, err
 	}
-	fmt.Printf("UID "+row.Columns[3].GetString_()  )
 
+	// The request's Permissions decide who can read it, by default its parties
+	if err := check_permission(stub, r.Permissions, RightRead, r.Requester, r.Approver); err != nil {
+		return nil, err
+	}
 
-	str := `{ "requestType": "` + row.Columns[0].GetString_() + `", "requester": "` + row.Columns[1].GetString_() + `", "approver": "` + row.Columns[2].GetString_() + `", "uid": "` + row.Columns[3].GetString_() + `", "data": ` + string(row.Columns[4].GetBytes()) + `, "status": "` + row.Columns[5].GetString_() + `", "permissions" : ` + string(row.Columns[6].GetBytes()) + `, "createdAt": "` + row.Columns[7].GetString_() + `"  }`
+	return json.Marshal(r)
+}
 
-	fmt.Printf(str)
+// RebuildIndexes () – indexes every request submitted before the UID and approver indexes existed
+func (t *Request) RebuildIndexes(stub Stub) (int, error) {
 
-	return []byte(str), nil
+	rows, err := stub.GetRows("RequestTable", keyColumns())
+	if err != nil {
+		return 0, fmt.Errorf("Failed to retrieve rows")
+	}
 
+	// Collect first, the rows channel must be drained before writing
+	var requests []RequestRecord
+	for row := range rows {
+		if len(row.Columns) != 0 {
+			requests = append(requests, requestFromRow(row))
+		}
+	}
+
+	count := 0
+	for _, r := range requests {
+		err = put_approver_index(stub, r)
+		if err != nil {
+			return count, err
+		}
+
+		key, found, err := get_uid_index(stub, requestUidIndex, r.Uid)
+		if err != nil {
+			return count, err
+		}
+		if found {
+			if key[0] != r.RequestType || key[1] != r.Requester || key[2] != r.Approver {
+				logger.Warningf("Request uid " + r.Uid + " is used more than once, only the first is indexed")
+			}
+			continue
+		}
+		err = put_uid_index(stub, requestUidIndex, r.Uid, r.RequestType, r.Requester, r.Approver)
+		if err != nil {
+			return count, err
+		}
+		count++
+	}
+	return count, nil
 }
 
-func (t *Request) ApproveRequest(stub *shim.ChaincodeStub, args []string) ([]byte, error) {
+func (t *Request) ApproveRequest(stub Stub, args []string) ([]byte, error) {
 
-	if len(args) != 3{
-		return nil, errors.New("Incorrect number of arguments. Expecting 3.")
+	if len(args) != 3 && len(args) != 4 {
+		return nil, argCount("3 or 4")
 	}
 
-	requestType := "new"
-	//requestType := args[1]
 	requester := args[0]
 	approver := args[1]
 	uid := args[2]
-	//requester := "testUser"
-	// Get the row pertaining to this UID
-	var columns []shim.Column
-	col1 := shim.Column{Value: &shim.Column_String_{String_: requestType}}
-	columns = append(columns, col1)
-	col2 := shim.Column{Value: &shim.Column_String_{String_: requester}}
-	columns = append(columns, col2)
-	col3 := shim.Column{Value: &shim.Column_String_{String_: approver}}
-	columns = append(columns, col3)
-	col4 := shim.Column{Value: &shim.Column_String_{String_: uid}}
-	columns = append(columns, col4)
+	requestType := requestTypeArg(args, 3)
+
+	r, err := t.get(stub, requestType, requester, approver, uid)
+	if err != nil {
+		return nil, err
+	}
+
+	// Only the approver named on the request can approve it, its Permissions can restrict that further
+	if err := check_permission(stub, r.Permissions, RightApprove, approver); err != nil {
+		return nil, err
+	}
+
+	// The LG is described by the request's DocJSON
+	var doc requestDoc
+	err = json.Unmarshal(r.DocJSON, &doc)
+	if err != nil {
+		return nil, invalidArgument(ErrorDetails{"field": "docJSON", "uid": uid}, "Invalid DocJSON on request %s: %s", uid, err.Error())
+	}
+	if doc.DocumentType == "" {
+		doc.DocumentType = "LG"
+	}
+	if doc.DocumentUid == "" {
+		doc.DocumentUid = uid
+	}
+
+	err = t.setStatus(stub, requestType, requester, approver, uid, StatusApproved, "", nil, doc.DocumentUid)
+	if err != nil {
+		return nil, err
+	}
+
+	// Issue or amend the document in the same transaction so both tables always agree
+	var document Document
+	if requestType == RequestTypeAmendment {
+		err = document.AmendDocument(stub, requester, approver, doc.DocumentType, doc.PreviousUid, doc.DocumentUid, r.DocJSON, r.Permissions, doc.ExpiryDate, uid)
+	} else {
+		err = document.issue(stub, DocumentRecord{
+			Owner:        requester,
+			Issuer:       approver,
+			DocumentType: doc.DocumentType,
+			Uid:          doc.DocumentUid,
+			DataJSON:     r.DocJSON,
+			Status:       DocStatusIssued,
+			Permissions:  r.Permissions,
+			ExpiryDate:   doc.ExpiryDate,
+			RequestUid:   uid,
+		})
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return json.Marshal(ApprovalResult{RequestUid: uid, DocumentUid: doc.DocumentUid})
+}
+
+// RejectRequest () – the approver turns down a request, giving a reason
+func (t *Request) RejectRequest(stub Stub, args []string) ([]byte, error) {
+
+	if len(args) != 4 && len(args) != 5 {
+		return nil, argCount("4 or 5")
+	}
+	requester := args[0]
+	approver := args[1]
+	uid := args[2]
+	reason := args[3]
+	requestType := requestTypeArg(args, 4)
+
+	if reason == "" {
+		return nil, invalidArgument(ErrorDetails{"field": "reason"}, "A reason is required to reject a request.")
+	}
+
+	// Only the approver named on the request can reject it
+	if err := check_party(stub, approver); err != nil {
+		return nil, err
+	}
+
+	return nil, t.setStatus(stub, requestType, requester, approver, uid, StatusRejected, reason, nil, "")
+}
+
+// ReturnRequest () – the approver sends a request back to the requester, asking for changes
+func (t *Request) ReturnRequest(stub Stub, args []string) ([]byte, error) {
+
+	if len(args) != 4 && len(args) != 5 {
+		return nil, argCount("4 or 5")
+	}
+	requester := args[0]
+	approver := args[1]
+	uid := args[2]
+	reason := args[3]
+	requestType := requestTypeArg(args, 4)
+
+	if reason == "" {
+		return nil, invalidArgument(ErrorDetails{"field": "reason"}, "A reason is required to return a request.")
+	}
+
+	// Only the approver named on the request can return it
+	if err := check_party(stub, approver); err != nil {
+		return nil, err
+	}
+
+	return nil, t.setStatus(stub, requestType, requester, approver, uid, StatusReturned, reason, nil, "")
+}
+
+// ReviewRequest () – the approver starts examining a submitted request
+func (t *Request) ReviewRequest(stub Stub, args []string) ([]byte, error) {
+
+	if len(args) != 3 && len(args) != 4 {
+		return nil, argCount("3 or 4")
+	}
+	requester := args[0]
+	approver := args[1]
+	uid := args[2]
+	requestType := requestTypeArg(args, 3)
+
+	// Only the approver named on the request can review it
+	if err := check_party(stub, approver); err != nil {
+		return nil, err
+	}
+
+	return nil, t.setStatus(stub, requestType, requester, approver, uid, StatusUnderReview, "", nil, "")
+}
+
+// WithdrawRequest () – the requester takes back a request that has not been decided yet
+func (t *Request) WithdrawRequest(stub Stub, args []string) ([]byte, error) {
+
+	if len(args) != 3 && len(args) != 4 {
+		return nil, argCount("3 or 4")
+	}
+	requester := args[0]
+	approver := args[1]
+	uid := args[2]
+	requestType := requestTypeArg(args, 3)
+
+	// Only the requester can withdraw its request
+	if err := check_party(stub, requester); err != nil {
+		return nil, err
+	}
+
+	return nil, t.setStatus(stub, requestType, requester, approver, uid, StatusWithdrawn, "", nil, "")
+}
+
+// SubmitRequest () – the requester submits a draft or a returned request, optionally
+// replacing its DocJSON
+func (t *Request) SubmitRequest(stub Stub, args []string) ([]byte, error) {
+
+	if len(args) < 3 || len(args) > 5 {
+		return nil, argCount("3 to 5")
+	}
+	requester := args[0]
+	approver := args[1]
+	uid := args[2]
+	requestType := requestTypeArg(args, 4)
+	var docJSON []byte
+	if len(args) > 3 {
+		docJSON = []byte(args[3])
+	}
+
+	// Only the requester can submit its request
+	if err := check_party(stub, requester); err != nil {
+		return nil, err
+	}
+
+	r, err := t.get(stub, requestType, requester, approver, uid)
+	if err != nil {
+		return nil, err
+	}
+	if len(docJSON) != 0 {
+		if err := checkJSON("DocJSON", docJSON); err != nil {
+			return nil, err
+		}
+		r.DocJSON = docJSON
+	}
+	if err := check_parties(stub, requester, approver, r.DocJSON); err != nil {
+		return nil, err
+	}
+	r.Status = StatusSubmitted
+	if err := r.checkDocumentType(stub); err != nil {
+		return nil, err
+	}
+
+	return nil, t.setStatus(stub, requestType, requester, approver, uid, StatusSubmitted, "", docJSON, "")
+}
 
-	row, err := stub.GetRow("RequestTable", columns)
+// get returns a request, or an error if it does not exist
+func (t *Request) get(stub Stub, requestType string, requester string, approver string, uid string) (RequestRecord, error) {
+
+	// Get the row pertaining to this UID
+	row, err := stub.GetRow("RequestTable", keyColumns(requestType, requester, approver, uid))
 	if err != nil {
-		return nil, fmt.Errorf("Error: Failed retrieving document with uid %s. Error %s", uid, err.Error())
+		return RequestRecord{}, fmt.Errorf("Error: Failed retrieving request with uid %s. Error %s", uid, err.Error())
 	}
 
 	// GetRows returns empty message if key does not exist
 	if len(row.Columns) == 0 {
-		return nil, nil
+		return RequestRecord{}, notFound(ErrorDetails{"kind": AuditKindRequest, "uid": uid}, "Request with uid %s does not exist.", uid)
+	}
+
+	return requestFromRow(row), nil
+}
+
+// setStatus moves a request to a new status if the transition is allowed. A non empty
+// docJSON replaces the request's DocJSON and a non empty documentUid records the
+// document issued from it.
+func (t *Request) setStatus(stub Stub, requestType string, requester string, approver string, uid string, status string, reason string, docJSON []byte, documentUid string) error {
+
+	r, err := t.get(stub, requestType, requester, approver, uid)
+	if err != nil {
+		return err
+	}
+
+	err = checkTransition(r.Status, status)
+	if err != nil {
+		return err
+	}
+
+	r.Status = status
+	r.Reason = reason
+	if len(docJSON) != 0 {
+		r.DocJSON = docJSON
+	}
+	if documentUid != "" {
+		r.DocumentUid = documentUid
 	}
-	//update status
-	ok, err := stub.ReplaceRow("RequestTable", shim.Row{
-		Columns: []*shim.Column{
-			&shim.Column{Value: &shim.Column_String_{String_: row.Columns[0].GetString_()}},
-			&shim.Column{Value: &shim.Column_String_{String_: row.Columns[1].GetString_()}},
-			&shim.Column{Value: &shim.Column_String_{String_: row.Columns[2].GetString_()}},
-			&shim.Column{Value: &shim.Column_String_{String_: row.Columns[3].GetString_()}},
-			&shim.Column{Value: &shim.Column_Bytes{Bytes: row.Columns[4].GetBytes()}},
-			&shim.Column{Value: &shim.Column_String_{String_: "approved"}},
-			&shim.Column{Value: &shim.Column_Bytes{Bytes: row.Columns[6].GetBytes()}},
-			&shim.Column{Value: &shim.Column_String_{String_: row.Columns[7].GetString_()}}},
+
+	return t.replace(stub, r)
+}
+
+// GetRequestsForApprover () – returns the requests waiting for an approver, newest first. By default
+// only pending requests are listed; status "*" lists every status.
+func (t *Request) GetRequestsForApprover(stub Stub, args []string) ([]byte, error) {
+
+	if len(args) < 1 || len(args) > 4 {
+		return nil, argCount("1 to 4")
+	}
+	approver := args[0]
+	status := ""
+	if len(args) > 1 {
+		status = args[1]
+	}
+	key := []string{approver}
+	if len(args) > 2 && args[2] != "" {
+		key = append(key, args[2])
+	}
+	o, err := listOptionsArg(args, 3)
+	if err != nil {
+		return nil, err
+	}
+	if status == "" && o.Status != "" {
+		status = o.Status
+	}
+	if status == "*" {
+		o.Status = ""
+	}
+
+	// Only the approver can list its inbox
+	if err := check_party(stub, approver); err != nil {
+		return nil, err
+	}
+
+	rows, err := stub.GetRows(approverIndex, keyColumns(key...))
+	if err != nil {
+		return nil, fmt.Errorf("Failed to retrieve rows")
+	}
+
+	// Collect the matching entries first, the rows channel must be drained before reading other rows
+	var entries []Row
+	for row := range rows {
+		if len(row.Columns) == 0 {
+			continue
+		}
+		entryStatus := row.Columns[4].GetString_()
+		if status == "" && entryStatus != StatusSubmitted && entryStatus != StatusUnderReview {
+			continue
+		}
+		if status != "" && status != "*" && status != entryStatus {
+			continue
+		}
+		// The document type is checked below, it is not part of the index
+		if !o.match(entryStatus, row.Columns[5].GetString_(), o.DocumentType) {
+			continue
+		}
+		entries = append(entries, row)
+	}
+
+	// Newest first
+	sort.Slice(entries, func(i, j int) bool {
+		a, b := entries[i].Columns[5].GetString_(), entries[j].Columns[5].GetString_()
+		if a != b {
+			return a > b
+		}
+		return entries[i].Columns[2].GetString_() > entries[j].Columns[2].GetString_()
 	})
 
-	if !ok && err == nil {
-		return nil, errors.New("Error updating.")
+	read := func(entry Row) (RequestRecord, error) {
+		return t.get(stub, entry.Columns[1].GetString_(), entry.Columns[3].GetString_(), approver, entry.Columns[2].GetString_())
+	}
+
+	// The document type is only known from the request itself, so filtering on it reads every entry
+	var requests []RequestRecord
+	if o.DocumentType != "" {
+		for _, entry := range entries {
+			r, err := read(entry)
+			if err != nil {
+				return nil, err
+			}
+			if r.documentType() == o.DocumentType {
+				requests = append(requests, r)
+			}
+		}
+	}
+
+	keys := make([]listKey, 0, len(entries))
+	if o.DocumentType != "" {
+		for _, r := range requests {
+			keys = append(keys, listKey{r.CreatedAt, r.Uid})
+		}
+	} else {
+		for _, entry := range entries {
+			keys = append(keys, listKey{entry.Columns[5].GetString_(), entry.Columns[2].GetString_()})
+		}
+	}
+
+	first, last, next, err := paginate(keys, o, true)
+	if err != nil {
+		return nil, err
 	}
 
-	return nil, err
+	page := []RequestRecord{}
+	if o.DocumentType != "" {
+		page = append(page, requests[first:last]...)
+	} else {
+		for _, entry := range entries[first:last] {
+			r, err := read(entry)
+			if err != nil {
+				return nil, err
+			}
+			page = append(page, r)
+		}
+	}
+
+	return json.Marshal(ListResponse{Count: len(page), Data: page, NextCursor: next})
 }
-func (t *Request) GetNewRequests(stub *shim.ChaincodeStub, args []string) ([]byte, error) {
 
-	if len(args) != 1 {
-		return nil, errors.New("Incorrect number of arguments. Expecting 1.")
+// GetNewRequests () – lists the new requests of a requester by uid
+func (t *Request) GetNewRequests(stub Stub, args []string) ([]byte, error) {
+
+	if len(args) != 1 && len(args) != 2 {
+		return nil, argCount("1 or 2")
 	}
 	requestType := "new"
 	requester := args[0]
-	fmt.Printf("Chaincode - Get List of Requests")
-		logger.Infof("Chaincode - Get List of Requests")
+	o, err := listOptionsArg(args, 1)
+	if err != nil {
+		return nil, err
+	}
 
-	// Get the row pertaining to this UID
-	var columns []shim.Column
-	col1 := shim.Column{Value: &shim.Column_String_{String_: requestType}}
-	columns = append(columns, col1)
-	col2 := shim.Column{Value: &shim.Column_String_{String_: requester}}
-	columns = append(columns, col2)
+	// Only the requester can list its requests
+	if err := check_party(stub, requester); err != nil {
+		return nil, err
+	}
+	logger.Infof("Chaincode - Get List of Requests")
 
-	rows, err := stub.GetRows("RequestTable", columns)
+	rows, err := stub.GetRows("RequestTable", keyColumns(requestType, requester))
 	if err != nil {
 		return nil, fmt.Errorf("Failed to retrieve row")
 	}
-	//var o string
-	var count int
-	var outputString string
+
+	requests := []RequestRecord{}
 	for row := range rows {
 		if len(row.Columns) != 0 {
-			//o = row.Columns[3].GetString_()
-			if (count == 0) {
-				outputString = "["
-			}else{
-				outputString = outputString + ", "
+			r := requestFromRow(row)
+			if o.match(r.Status, r.CreatedAt, r.documentType()) {
+				requests = append(requests, r)
 			}
-			logger.Debugf(" UID "+row.Columns[3].GetString_() )
-			str := `{ "requestType": "` + row.Columns[0].GetString_() + `", "requester": "` + row.Columns[1].GetString_() + `", "approver": "` + row.Columns[2].GetString_() + `", "uid": "` + row.Columns[3].GetString_() + `", "data": ` + string(row.Columns[4].GetBytes()) + `, "status": "` + row.Columns[5].GetString_() + `", "permissions" : ` + string(row.Columns[6].GetBytes()) + `, "createdAt": "` + row.Columns[7].GetString_() + `"  }`
-			logger.Debugf("str "+str )
-			outputString = outputString + str
-			count++
 		}
 	}
-	outputString = outputString + "]"
-	if( count ==0){
-		outputString = `[]`
-	}
-	logger.Debugf("requests: "+outputString )
-
-
-
 
-	//str := `{ "requestType": "` + row.Columns[0].GetString_() + `", "requester": "` + row.Columns[1].GetString_() + `", "approver": "` + row.Columns[2].GetString_() + `", "uid": "` + row.Columns[3].GetString_() + `", "data": ` + string(row.Columns[4].GetBytes()) + `, "status": "` + row.Columns[5].GetString_() + `", "permissions" : ` + string(row.Columns[6].GetBytes()) + `, "createdAt": "` + row.Columns[7].GetString_() + `"  }`
+	// Requests of one requester may sit under different approvers, order them by uid
+	sort.Slice(requests, func(i, j int) bool {
+		return requests[i].Uid < requests[j].Uid
+	})
 
-	//fmt.Printf(str)
+	keys := make([]listKey, len(requests))
+	for i, r := range requests {
+		keys[i] = listKey{r.Uid}
+	}
+	first, last, next, err := paginate(keys, o, false)
+	if err != nil {
+		return nil, err
+	}
 
-	//return []byte(str), nil
-return []byte(`{"count": `+strconv.Itoa(count)+`, "data":`+outputString+` }`) , nil
+	page := requests[first:last]
+	return json.Marshal(ListResponse{Count: len(page), Data: page, NextCursor: next})
 }
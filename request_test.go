@@ -0,0 +1,334 @@
+package main
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func getRequest(h *testChaincode, uid string) RequestRecord {
+	h.t.Helper()
+	var r RequestRecord
+	h.auditor().mustQueryInto(&r, "get_request_by_uid", uid)
+	return r
+}
+
+func TestSubmitNewRequest(t *testing.T) {
+	h := newTestChaincode(t)
+	h.submitRequest("R1", 1000)
+
+	var r RequestRecord
+	h.applicant().mustQueryInto(&r, "get_request_json", "CorpB", "BankA", "R1")
+	if r.Status != StatusSubmitted || r.CreatedAt != "2017-01-02T09:00:00Z" {
+		t.Fatalf("unexpected request %+v", r)
+	}
+
+	// Uids are unique across parties
+	h.admin().mustInvoke("register_organisation", `{"id": "BankZ", "name": "Bank Z", "type": "bank", "bic": "BKZZFRPP", "country": "FR"}`)
+	_, err := h.applicant().invoke("submit_new_request", RequestTypeNew, "CorpB", "BankZ", "R1", lgDocJSON(1), StatusSubmitted, "{}")
+	expectCode(t, err, CodeAlreadyExists)
+	_, err = h.invoke("submit_new_request", RequestTypeNew, "CorpB", "BankA", "R2", lgDocJSON(1), StatusApproved, "{}")
+	expectCode(t, err, CodeInvalidArgument)
+	_, err = h.invoke("submit_new_request", RequestTypeNew, "CorpZ", "BankA", "R2", lgDocJSON(1), StatusSubmitted, "{}")
+	expectCode(t, err, CodeForbidden)
+	_, err = h.invoke("submit_new_request", RequestTypeNew, "CorpB", "BankA", "R2", lgDocJSON(1), StatusSubmitted, `{"parties": {"BankA": ["print"]}}`)
+	expectCode(t, err, CodeInvalidArgument)
+
+	// A request submitted without a uid is given one
+	var created CreatedResponse
+	if err := json.Unmarshal(h.mustInvoke("submit_new_request", RequestTypeNew, "CorpB", "BankA", "", lgDocJSON(1), StatusSubmitted, "{}"), &created); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(created.Uid, "Rtx") || getRequest(h, created.Uid).Uid != created.Uid {
+		t.Fatalf("unexpected uid %q", created.Uid)
+	}
+}
+
+func TestGetRequestJSON(t *testing.T) {
+	h := newTestChaincode(t)
+	h.submitRequest("R1", 1000)
+
+	var r RequestRecord
+	h.officer().mustQueryInto(&r, "get_request_json", "CorpB", "BankA", "R1", RequestTypeNew)
+	if r.Uid != "R1" {
+		t.Fatalf("unexpected request %+v", r)
+	}
+
+	_, err := h.query("get_request_json", "CorpB", "BankA", "R9")
+	expectCode(t, err, CodeNotFound)
+	_, err = h.as("eve", RoleApplicant, "CorpE").query("get_request_json", "CorpB", "BankA", "R1")
+	expectCode(t, err, CodeForbidden)
+}
+
+func TestGetRequestByUid(t *testing.T) {
+	h := newTestChaincode(t)
+	h.submitRequest("R1", 1000)
+
+	var r RequestRecord
+	h.applicant().mustQueryInto(&r, "get_request_by_uid", "R1")
+	if r.Requester != "CorpB" || r.Approver != "BankA" {
+		t.Fatalf("unexpected request %+v", r)
+	}
+
+	// Parties can not probe which UIDs are in use
+	_, err := h.query("get_request_by_uid", "R9")
+	expectCode(t, err, CodeForbidden)
+	_, err = h.auditor().query("get_request_by_uid", "R9")
+	expectCode(t, err, CodeNotFound)
+	_, err = h.as("eve", RoleBankOfficer, "BankE").query("get_request_by_uid", "R1")
+	expectCode(t, err, CodeForbidden)
+
+	// A list that grants CorpB nothing keeps the request from it
+	h.applicant().mustInvoke("submit_new_request", RequestTypeNew, "CorpB", "BankA", "R2", lgDocJSON(1), StatusSubmitted, `{"parties": {"BankA": ["read"]}}`)
+	_, err = h.query("get_request_by_uid", "R2")
+	expectCode(t, err, CodeForbidden)
+	h.officer().mustQuery("get_request_by_uid", "R2")
+}
+
+func TestApproveNewRequest(t *testing.T) {
+	h := newTestChaincode(t)
+	h.submitRequest("R1", 1000)
+
+	var res ApprovalResult
+	if err := json.Unmarshal(h.officer().mustInvoke("approve_new_request", "CorpB", "BankA", "R1"), &res); err != nil {
+		t.Fatal(err)
+	}
+	if res.RequestUid != "R1" || res.DocumentUid != "R1" {
+		t.Fatalf("unexpected result %+v", res)
+	}
+
+	r := getRequest(h, "R1")
+	if r.Status != StatusApproved || r.DocumentUid != "R1" {
+		t.Fatalf("unexpected request %+v", r)
+	}
+	var d DocumentRecord
+	h.officer().mustQueryInto(&d, "get_lg_document_json", "CorpB", "BankA", "R1")
+	if d.Status != DocStatusIssued || d.AvailableAmount != 1000 || d.RequestUid != "R1" {
+		t.Fatalf("unexpected document %+v", d)
+	}
+
+	_, err := h.invoke("approve_new_request", "CorpB", "BankA", "R1")
+	expectCode(t, err, CodeInvalidTransition)
+	_, err = h.as("eve", RoleBankOfficer, "BankE").invoke("approve_new_request", "CorpB", "BankA", "R1")
+	expectCode(t, err, CodeForbidden)
+}
+
+func TestApprovePermissions(t *testing.T) {
+	h := newTestChaincode(t)
+
+	// A list granting approve to every bank officer does not let another bank approve, nor cancel the LG
+	h.applicant().mustInvoke("submit_new_request", RequestTypeNew, "CorpB", "BankA", "R1", lgDocJSON(1000), StatusSubmitted, `{"roles": {"bank_officer": ["approve", "cancel"]}}`)
+	_, err := h.as("eve", RoleBankOfficer, "BankE").invoke("approve_new_request", "CorpB", "BankA", "R1")
+	expectCode(t, err, CodeForbidden)
+	h.officer().mustInvoke("approve_new_request", "CorpB", "BankA", "R1")
+	_, err = h.as("eve", RoleBankOfficer, "BankE").invoke("cancel_lg_document", "CorpB", "BankA", "R1")
+	expectCode(t, err, CodeForbidden)
+
+	// A list that does not mention approve leaves it to the approver
+	h.applicant().mustInvoke("submit_new_request", RequestTypeNew, "CorpB", "BankA", "R2", lgDocJSON(1000), StatusSubmitted, `{"parties": {"CorpB": ["read"]}}`)
+	h.officer().mustInvoke("approve_new_request", "CorpB", "BankA", "R2")
+
+	// A list granting approve to others only restricts it
+	h.applicant().mustInvoke("submit_new_request", RequestTypeNew, "CorpB", "BankA", "R3", lgDocJSON(1000), StatusSubmitted, `{"parties": {"CorpB": ["approve"]}}`)
+	_, err = h.officer().invoke("approve_new_request", "CorpB", "BankA", "R3")
+	expectCode(t, err, CodeForbidden)
+}
+
+func TestRejectRequest(t *testing.T) {
+	h := newTestChaincode(t)
+	h.submitRequest("R1", 1000)
+
+	_, err := h.officer().invoke("reject_request", "CorpB", "BankA", "R1", "")
+	expectCode(t, err, CodeInvalidArgument)
+
+	h.mustInvoke("reject_request", "CorpB", "BankA", "R1", "Insufficient collateral")
+	r := getRequest(h, "R1")
+	if r.Status != StatusRejected || r.Reason != "Insufficient collateral" {
+		t.Fatalf("unexpected request %+v", r)
+	}
+	if last := h.stub.Events[len(h.stub.Events)-1]; last.Name != EventRequestRejected {
+		t.Fatalf("expected %s, got %s", EventRequestRejected, last.Name)
+	}
+}
+
+func TestReviewAndReturnRequest(t *testing.T) {
+	h := newTestChaincode(t)
+	h.submitRequest("R1", 1000)
+
+	h.officer().mustInvoke("review_request", "CorpB", "BankA", "R1")
+	if r := getRequest(h, "R1"); r.Status != StatusUnderReview {
+		t.Fatalf("expected under_review, got %s", r.Status)
+	}
+
+	h.officer().mustInvoke("return_request", "CorpB", "BankA", "R1", "Please add the contract reference")
+	if r := getRequest(h, "R1"); r.Status != StatusReturned || r.Reason == "" {
+		t.Fatalf("unexpected request %+v", r)
+	}
+
+	_, err := h.officer().invoke("review_request", "CorpB", "BankA", "R1")
+	expectCode(t, err, CodeInvalidTransition)
+}
+
+func TestSubmitRequest(t *testing.T) {
+	h := newTestChaincode(t)
+	h.applicant().mustInvoke("submit_new_request", RequestTypeNew, "CorpB", "BankA", "R1", `{"amount": 1000}`, StatusDraft, "{}")
+
+	// A draft is not in the approver's inbox
+	var inbox listOf
+	h.officer().mustQueryInto(&inbox, "get_requests_for_approver", "BankA")
+	if inbox.Count != 0 {
+		t.Fatalf("expected an empty inbox, got %+v", inbox)
+	}
+
+	h.applicant().mustInvoke("submit_request", "CorpB", "BankA", "R1", lgDocJSON(2000))
+	r := getRequest(h, "R1")
+	if r.Status != StatusSubmitted {
+		t.Fatalf("expected submitted, got %s", r.Status)
+	}
+	var doc documentTerms
+	json.Unmarshal(r.DocJSON, &doc)
+	if doc.Amount != 2000 {
+		t.Fatalf("expected the DocJSON to be replaced, got %s", r.DocJSON)
+	}
+
+	_, err := h.applicant().invoke("submit_request", "CorpB", "BankA", "R1")
+	expectCode(t, err, CodeInvalidTransition)
+	_, err = h.invoke("submit_request", "CorpB", "BankA", "R9")
+	expectCode(t, err, CodeNotFound)
+}
+
+func TestWithdrawRequest(t *testing.T) {
+	h := newTestChaincode(t)
+	h.submitRequest("R1", 1000)
+
+	_, err := h.officer().invoke("withdraw_request", "CorpB", "BankA", "R1")
+	expectCode(t, err, CodeForbidden)
+
+	h.applicant().mustInvoke("withdraw_request", "CorpB", "BankA", "R1")
+	if r := getRequest(h, "R1"); r.Status != StatusWithdrawn {
+		t.Fatalf("expected withdrawn, got %s", r.Status)
+	}
+
+	_, err = h.officer().invoke("approve_new_request", "CorpB", "BankA", "R1")
+	expectCode(t, err, CodeInvalidTransition)
+}
+
+func TestAmendLGDocument(t *testing.T) {
+	h := newTestChaincode(t)
+	h.issueLG("R1", 1000)
+
+	amendment := `{"previousUid": "R1", "documentUid": "R1-A", "amount": 1500, "expiryDate": "2018-06-30"}`
+	h.applicant().mustInvoke("amend_lg_document", "CorpB", "BankA", "A1", amendment, "{}")
+	h.officer().mustInvoke("approve_new_request", "CorpB", "BankA", "A1", RequestTypeAmendment)
+
+	var history listOf
+	h.officer().mustQueryInto(&history, "get_lg_history", "CorpB", "BankA", "R1-A")
+	var versions []DocumentRecord
+	json.Unmarshal(history.Data, &versions)
+	if history.Count != 2 || versions[0].Status != DocStatusSuperseded || versions[1].Uid != "R1-A" {
+		t.Fatalf("unexpected history %+v", versions)
+	}
+	if versions[1].AvailableAmount != 1500 || versions[1].ExpiryDate != "2018-06-30" {
+		t.Fatalf("unexpected amended document %+v", versions[1])
+	}
+
+	// The superseded version can no longer be amended
+	_, err := h.applicant().invoke("amend_lg_document", "CorpB", "BankA", "A2", amendment, "{}")
+	expectCode(t, err, CodeInvalidTransition)
+	_, err = h.invoke("amend_lg_document", "CorpB", "BankA", "A2", `{"amount": 1}`, "{}")
+	expectCode(t, err, CodeInvalidArgument)
+}
+
+func TestAmendKeepsPermissions(t *testing.T) {
+	h := newTestChaincode(t)
+	acl := `{"parties": {"BankA": ["read", "approve", "cancel"], "CorpB": ["read", "amend"]}}`
+	h.applicant().mustInvoke("submit_new_request", RequestTypeNew, "CorpB", "BankA", "R1", lgDocJSON(1000), StatusSubmitted, acl)
+	h.officer().mustInvoke("approve_new_request", "CorpB", "BankA", "R1")
+
+	// An amendment without Permissions keeps the list of the document
+	h.applicant().mustInvoke("amend_lg_document", "CorpB", "BankA", "A1", `{"previousUid": "R1", "documentUid": "R1-A", "amount": 1500, "beneficiary": "SupplierC"}`, "")
+	h.officer().mustInvoke("approve_new_request", "CorpB", "BankA", "A1", RequestTypeAmendment)
+
+	var d DocumentRecord
+	h.mustQueryInto(&d, "get_lg_by_uid", "R1-A")
+	var got, want Permissions
+	json.Unmarshal(d.Permissions, &got)
+	json.Unmarshal([]byte(acl), &want)
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("expected the list of R1, got %s", d.Permissions)
+	}
+	_, err := h.beneficiary().query("get_lg_by_uid", "R1-A")
+	expectCode(t, err, CodeForbidden)
+
+	// An amendment with its own list replaces it
+	h.applicant().mustInvoke("amend_lg_document", "CorpB", "BankA", "A2", `{"previousUid": "R1-A", "documentUid": "R1-B", "amount": 1500, "beneficiary": "SupplierC"}`, "{}")
+	h.officer().mustInvoke("approve_new_request", "CorpB", "BankA", "A2", RequestTypeAmendment)
+	h.beneficiary().mustQuery("get_lg_by_uid", "R1-B")
+}
+
+func TestGetNewRequests(t *testing.T) {
+	h := newTestChaincode(t)
+	h.submitRequest("R2", 1000)
+	h.submitRequest("R1", 1000)
+	h.submitRequest("R3", 1000)
+	h.applicant().mustInvoke("withdraw_request", "CorpB", "BankA", "R3")
+
+	var page listOf
+	h.applicant().mustQueryInto(&page, "get_new_requests", "CorpB", `{"pageSize": 2}`)
+	var requests []RequestRecord
+	json.Unmarshal(page.Data, &requests)
+	if page.Count != 2 || requests[0].Uid != "R1" || requests[1].Uid != "R2" || page.NextCursor == "" {
+		t.Fatalf("unexpected first page %+v", page)
+	}
+
+	h.mustQueryInto(&page, "get_new_requests", "CorpB", `{"status": "withdrawn"}`)
+	json.Unmarshal(page.Data, &requests)
+	if page.Count != 1 || requests[0].Uid != "R3" {
+		t.Fatalf("unexpected filtered page %+v", page)
+	}
+
+	_, err := h.query("get_new_requests", "CorpZ")
+	expectCode(t, err, CodeForbidden)
+}
+
+func TestGetRequestsForApprover(t *testing.T) {
+	h := newTestChaincode(t)
+	h.submitRequest("R1", 1000)
+	h.stub.Time = h.stub.Time.Add(3600e9)
+	h.submitRequest("R2", 1000)
+	h.submitRequest("R3", 1000)
+	h.officer().mustInvoke("reject_request", "CorpB", "BankA", "R3", "No")
+
+	var page listOf
+	h.officer().mustQueryInto(&page, "get_requests_for_approver", "BankA")
+	var requests []RequestRecord
+	json.Unmarshal(page.Data, &requests)
+	if page.Count != 2 || requests[0].Uid != "R2" || requests[1].Uid != "R1" {
+		t.Fatalf("expected the pending requests newest first, got %+v", requests)
+	}
+
+	h.mustQueryInto(&page, "get_requests_for_approver", "BankA", "*", RequestTypeNew, `{"pageSize": 1}`)
+	json.Unmarshal(page.Data, &requests)
+	if page.Count != 1 || requests[0].Uid != "R3" || page.NextCursor == "" {
+		t.Fatalf("unexpected page %+v", requests)
+	}
+
+	h.mustQueryInto(&page, "get_requests_for_approver", "BankA", StatusRejected)
+	if page.Count != 1 {
+		t.Fatalf("expected 1 rejected request, got %d", page.Count)
+	}
+
+	_, err := h.query("get_requests_for_approver", "BankZ")
+	expectCode(t, err, CodeForbidden)
+
+	// Without a request type, both forms list the amendments too
+	h.issueLG("R4", 1000)
+	h.applicant().mustInvoke("amend_lg_document", "CorpB", "BankA", "A1", `{"previousUid": "R4", "documentUid": "R4-A", "amount": 1500}`, "{}")
+	var named listOf
+	h.officer().mustQueryInto(&page, "get_requests_for_approver", "BankA")
+	h.mustQueryInto(&named, "get_requests_for_approver", namedArgsMarker, `{"apiVersion": "1", "approver": "BankA"}`)
+	if page.Count != 3 || named.Count != page.Count {
+		t.Fatalf("expected the same 3 pending requests, got %d and %d", page.Count, named.Count)
+	}
+}
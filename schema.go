@@ -0,0 +1,303 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"regexp"
+	"sort"
+	"strings"
+	"unicode/utf8"
+)
+
+//==============================================================================================================================
+//	 Schemas - The DataJSON of a document, and the DocJSON of the request for it, can be checked against a JSON
+//			   Schema registered for its DocumentType. Only a subset of JSON Schema is supported:
+//				type, required, properties (nested objects), items, enum, pattern, minimum and maxLength.
+//			   Other keywords are rejected when the schema is registered, except the annotations
+//			   $schema, title and description.
+//==============================================================================================================================
+
+type jsonSchema struct {
+	Annotation  string                 `json:"$schema"`
+	Title       string                 `json:"title"`
+	Description string                 `json:"description"`
+	Type        string                 `json:"type"`
+	Required    []string               `json:"required"`
+	Properties  map[string]*jsonSchema `json:"properties"`
+	Items       *jsonSchema            `json:"items"`
+	Enum        []interface{}          `json:"enum"`
+	Pattern     string                 `json:"pattern"`
+	Minimum     *json.Number           `json:"minimum"`
+	MaxLength   *int                   `json:"maxLength"`
+
+	pattern *regexp.Regexp
+	minimum *big.Float
+}
+
+var schemaTypes = []string{"object", "array", "string", "number", "integer", "boolean", "null"}
+
+// parseSchema reads a schema and checks that it only uses the supported keywords
+func parseSchema(schemaJSON []byte) (*jsonSchema, error) {
+	var s jsonSchema
+	dec := json.NewDecoder(bytes.NewReader(schemaJSON))
+	dec.DisallowUnknownFields()
+	dec.UseNumber()
+	if err := dec.Decode(&s); err != nil {
+		return nil, invalidArgument(ErrorDetails{"field": "schema"}, "Invalid schema: %s", err.Error())
+	}
+	if err := s.compile("$"); err != nil {
+		return nil, err
+	}
+	return &s, nil
+}
+
+// compile checks the keywords of a schema and its subschemas, and prepares pattern and minimum
+func (s *jsonSchema) compile(path string) error {
+	if s.Type != "" && !contains(schemaTypes, s.Type) {
+		return invalidArgument(ErrorDetails{"field": "schema", "path": path}, "Invalid schema at %s: unknown type %s", path, s.Type)
+	}
+	if s.Pattern != "" {
+		re, err := regexp.Compile(s.Pattern)
+		if err != nil {
+			return invalidArgument(ErrorDetails{"field": "schema", "path": path}, "Invalid schema at %s: %s", path, err.Error())
+		}
+		s.pattern = re
+	}
+	if s.Minimum != nil {
+		min, ok := new(big.Float).SetString(s.Minimum.String())
+		if !ok {
+			return invalidArgument(ErrorDetails{"field": "schema", "path": path}, "Invalid schema at %s: minimum must be a number", path)
+		}
+		s.minimum = min
+	}
+	if s.MaxLength != nil && *s.MaxLength < 0 {
+		return invalidArgument(ErrorDetails{"field": "schema", "path": path}, "Invalid schema at %s: maxLength can not be negative", path)
+	}
+	for name, property := range s.Properties {
+		if property == nil {
+			return invalidArgument(ErrorDetails{"field": "schema", "path": path}, "Invalid schema at %s: property %s has no schema", path, name)
+		}
+		if err := property.compile(path + "." + name); err != nil {
+			return err
+		}
+	}
+	if s.Items != nil {
+		if err := s.Items.compile(path + "[]"); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// validate returns an error listing every violation of the schema by document, with its JSON path
+func (s *jsonSchema) validate(name string, document []byte) error {
+	var value interface{}
+	dec := json.NewDecoder(bytes.NewReader(document))
+	dec.UseNumber()
+	if err := dec.Decode(&value); err != nil {
+		return invalidArgument(ErrorDetails{"field": name}, "Invalid %s: %s", name, err.Error())
+	}
+
+	var violations []string
+	s.check(value, "$", &violations)
+	if len(violations) != 0 {
+		return invalidArgument(ErrorDetails{"field": name, "violations": violations}, "Invalid %s: %s", name, strings.Join(violations, "; "))
+	}
+	return nil
+}
+
+// check appends the violations of value at path to violations
+func (s *jsonSchema) check(value interface{}, path string, violations *[]string) {
+	report := func(format string, a ...interface{}) {
+		*violations = append(*violations, path+": "+fmt.Sprintf(format, a...))
+	}
+
+	if s.Type != "" && !hasSchemaType(value, s.Type) {
+		report("expected %s", s.Type)
+		return
+	}
+
+	if len(s.Enum) != 0 {
+		valueAsBytes, _ := json.Marshal(value)
+		found := false
+		for _, option := range s.Enum {
+			optionAsBytes, _ := json.Marshal(option)
+			if bytes.Equal(valueAsBytes, optionAsBytes) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			report("must be one of the enumerated values")
+		}
+	}
+
+	switch v := value.(type) {
+	case string:
+		if s.pattern != nil && !s.pattern.MatchString(v) {
+			report("does not match pattern %s", s.Pattern)
+		}
+		if s.MaxLength != nil && utf8.RuneCountInString(v) > *s.MaxLength {
+			report("longer than %d characters", *s.MaxLength)
+		}
+	case json.Number:
+		if s.minimum != nil {
+			n, ok := new(big.Float).SetString(v.String())
+			if ok && n.Cmp(s.minimum) < 0 {
+				report("lower than the minimum %s", s.Minimum.String())
+			}
+		}
+	case map[string]interface{}:
+		for _, name := range s.Required {
+			if _, found := v[name]; !found {
+				*violations = append(*violations, path+"."+name+": required")
+			}
+		}
+		// Sorted so violations are reported in the same order on every peer
+		names := make([]string, 0, len(s.Properties))
+		for name := range s.Properties {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			if property, found := v[name]; found {
+				s.Properties[name].check(property, path+"."+name, violations)
+			}
+		}
+	case []interface{}:
+		if s.Items != nil {
+			for i, item := range v {
+				s.Items.check(item, fmt.Sprintf("%s[%d]", path, i), violations)
+			}
+		}
+	}
+}
+
+// hasSchemaType returns true if a decoded JSON value is of the given schema type
+func hasSchemaType(value interface{}, schemaType string) bool {
+	switch v := value.(type) {
+	case nil:
+		return schemaType == "null"
+	case bool:
+		return schemaType == "boolean"
+	case string:
+		return schemaType == "string"
+	case map[string]interface{}:
+		return schemaType == "object"
+	case []interface{}:
+		return schemaType == "array"
+	case json.Number:
+		if schemaType == "number" {
+			return true
+		}
+		if schemaType == "integer" {
+			n, ok := new(big.Float).SetString(v.String())
+			return ok && n.IsInt()
+		}
+	}
+	return false
+}
+
+//==============================================================================================================================
+//	 Schema registry - One schema per DocumentType, kept in the DocumentSchemaTable
+//==============================================================================================================================
+
+// create_schema_table creates the schema table if it does not exist yet
+func create_schema_table(stub Stub) error {
+	_, err := stub.GetTable("DocumentSchemaTable")
+	if err == nil {
+		// Table already exists; do not recreate
+		return nil
+	}
+
+	err = stub.CreateTable("DocumentSchemaTable", []*ColumnDefinition{
+		&ColumnDefinition{Name: "Type", Type: ColumnDefinition_STRING, Key: true},
+		&ColumnDefinition{Name: "Schema", Type: ColumnDefinition_BYTES, Key: false},
+	})
+	if err != nil {
+		return errors.New("Failed creating DocumentSchemaTable.")
+	}
+	return nil
+}
+
+// RegisterSchema () – sets the schema the documents of a registered type must follow
+func (t *DocumentTypes) RegisterSchema(stub Stub, args []string) ([]byte, error) {
+
+	//Args
+	//			0				1
+	//		documentType	schema JSON object (as string)
+	if len(args) != 2 {
+		return nil, argCount("2")
+	}
+	documentType := args[0]
+	schemaJSON := []byte(args[1])
+
+	if _, err := get_document_type(stub, documentType); err != nil {
+		return nil, err
+	}
+	if _, err := parseSchema(schemaJSON); err != nil {
+		return nil, err
+	}
+
+	row := Row{
+		Columns: []*Column{
+			&Column{Value: &Column_String_{String_: documentType}},
+			&Column{Value: &Column_Bytes{Bytes: schemaJSON}}},
+	}
+	ok, err := stub.ReplaceRow("DocumentSchemaTable", row)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		ok, err = stub.InsertRow("DocumentSchemaTable", row)
+		if !ok && err == nil {
+			return nil, errors.New("Error registering the schema of document type " + documentType + ".")
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return nil, audit(stub, AuditKindDocumentType, documentType, []string{documentType}, "", "")
+}
+
+// GetSchema () – returns the schema registered for a document type, or null if it has none
+func (t *DocumentTypes) GetSchema(stub Stub, args []string) ([]byte, error) {
+
+	if len(args) != 1 {
+		return nil, argCount("1")
+	}
+	schemaJSON, err := get_schema(stub, args[0])
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(rawJSON(schemaJSON))
+}
+
+// get_schema returns the schema registered for a document type, if any
+func get_schema(stub Stub, documentType string) ([]byte, error) {
+	row, err := stub.GetRow("DocumentSchemaTable", keyColumns(documentType))
+	if err != nil {
+		return nil, fmt.Errorf("Error: Failed retrieving the schema of document type %s. Error %s", documentType, err.Error())
+	}
+	if len(row.Columns) == 0 {
+		return nil, nil
+	}
+	return row.Columns[1].GetBytes(), nil
+}
+
+// validate_document checks a document against the schema of its type. Types without a schema accept any JSON.
+func validate_document(stub Stub, documentType string, name string, document []byte) error {
+	schemaJSON, err := get_schema(stub, documentType)
+	if err != nil || len(schemaJSON) == 0 {
+		return err
+	}
+	s, err := parseSchema(schemaJSON)
+	if err != nil {
+		return err
+	}
+	return s.validate(name, document)
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"errors"
+	"time"
+
+	"github.com/hyperledger/fabric-chaincode-go/pkg/cid"
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+)
+
+//==============================================================================================================================
+//	 Stub - The ledger operations the chaincode uses. Handlers take a Stub rather than the peer's
+//			shim.ChaincodeStubInterface, so they run unchanged against the in-memory MemStub (memstub.go) in tests.
+//			Init and Invoke wrap the peer's stub in a peerStub and hand it on.
+//==============================================================================================================================
+
+type Stub interface {
+	// State
+	GetState(key string) ([]byte, error)
+	PutState(key string, value []byte) error
+	DelState(key string) error
+	GetStateByPartialCompositeKey(objectType string, keys []string) (shim.StateQueryIteratorInterface, error)
+
+	// Tables, see table.go
+	CreateTable(name string, columnDefinitions []*ColumnDefinition) error
+	GetTable(tableName string) (*Table, error)
+	DeleteTable(tableName string) error
+	InsertRow(tableName string, row Row) (bool, error)
+	ReplaceRow(tableName string, row Row) (bool, error)
+	GetRow(tableName string, key []Column) (Row, error)
+	GetRows(tableName string, key []Column) (<-chan Row, error)
+	ScanRows(tableName string, key []Column, f func(Row) (bool, error)) error
+	DeleteRow(tableName string, key []Column) error
+
+	// Events
+	SetEvent(name string, payload []byte) error
+
+	// Transaction and caller
+	TxID() string
+	Invocation() *Invocation
+	TxTime() (time.Time, error)
+	GetTransient() (map[string][]byte, error)
+	ReadCertAttribute(attributeName string) ([]byte, error)
+	VerifyAttribute(attributeName string, attributeValue []byte) (bool, error)
+}
+
+// Invocation is what the chaincode remembers about the transaction it is running, besides its writes.
+// Every transaction starts with an empty Invocation, so nothing outlives the transaction.
+type Invocation struct {
+	Function    string           //Function invoked, recorded in the audit trail
+	Transitions []LifecycleEvent //Lifecycle transitions made so far, published as one event (events.go)
+	IDs         int              //IDs allocated so far by new_id (ids.go)
+	Audited     map[string]int   //Audit entries written so far, by kind/uid (audit.go)
+}
+
+// peerStub is the Stub of a transaction executed by a peer
+type peerStub struct {
+	shim.ChaincodeStubInterface
+	*tables
+	invocation *Invocation
+}
+
+func newPeerStub(stub shim.ChaincodeStubInterface) peerStub {
+	return peerStub{ChaincodeStubInterface: stub, tables: newTables(stub), invocation: &Invocation{}}
+}
+
+func (s peerStub) Invocation() *Invocation {
+	return s.invocation
+}
+
+func (s peerStub) TxID() string {
+	return s.GetTxID()
+}
+
+func (s peerStub) TxTime() (time.Time, error) {
+	ts, err := s.GetTxTimestamp()
+	if err != nil || ts == nil {
+		return time.Time{}, errors.New("Failed to get transaction timestamp")
+	}
+	return time.Unix(ts.Seconds, int64(ts.Nanos)).UTC(), nil
+}
+
+// ReadCertAttribute reads an attribute of the caller's certificate, as issued by the Fabric CA
+func (s peerStub) ReadCertAttribute(attributeName string) ([]byte, error) {
+	value, found, err := cid.GetAttributeValue(s.ChaincodeStubInterface, attributeName)
+	if err != nil {
+		return nil, err
+	}
+	if !found {
+		return nil, errors.New("Attribute " + attributeName + " not found")
+	}
+	return []byte(value), nil
+}
+
+func (s peerStub) VerifyAttribute(attributeName string, attributeValue []byte) (bool, error) {
+	err := cid.AssertAttributeValue(s.ChaincodeStubInterface, attributeName, string(attributeValue))
+	return err == nil, nil
+}
@@ -0,0 +1,481 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+)
+
+//==============================================================================================================================
+//	 Tables - Fabric 1.x and later only store keys and values. The tables of the chaincode are kept on top of them
+//			  with composite keys, so the models kept the table API they were written against on Fabric 0.6:
+//		table definition	("_table", name) -> JSON column definitions
+//		row					(name, key column values...) -> JSON array of the column values
+//	GetRows with fewer key values than the table has key columns is a partial composite key query, so it
+//	returns whole key columns only and in key order, like the Fabric 0.6 GetRows did.
+//==============================================================================================================================
+
+// tableDefinitionType is the composite key object type of the table definitions
+const tableDefinitionType = "_table"
+
+type ColumnDefinition_Type int32
+
+const (
+	ColumnDefinition_STRING ColumnDefinition_Type = 0
+	ColumnDefinition_INT32  ColumnDefinition_Type = 1
+	ColumnDefinition_INT64  ColumnDefinition_Type = 2
+	ColumnDefinition_UINT32 ColumnDefinition_Type = 3
+	ColumnDefinition_UINT64 ColumnDefinition_Type = 4
+	ColumnDefinition_BYTES  ColumnDefinition_Type = 5
+	ColumnDefinition_BOOL   ColumnDefinition_Type = 6
+)
+
+type ColumnDefinition struct {
+	Name string                `json:"name"`
+	Type ColumnDefinition_Type `json:"type"`
+	Key  bool                  `json:"key"`
+}
+
+type Table struct {
+	Name              string              `json:"name"`
+	ColumnDefinitions []*ColumnDefinition `json:"columnDefinitions"`
+}
+
+type Row struct {
+	Columns []*Column
+}
+
+// Column holds one value of a row. Value is one of the Column_* types.
+type Column struct {
+	Value isColumn_Value
+}
+
+type isColumn_Value interface {
+	isColumn_Value()
+}
+
+type Column_String_ struct{ String_ string }
+type Column_Int32 struct{ Int32 int32 }
+type Column_Int64 struct{ Int64 int64 }
+type Column_Uint32 struct{ Uint32 uint32 }
+type Column_Uint64 struct{ Uint64 uint64 }
+type Column_Bytes struct{ Bytes []byte }
+type Column_Bool struct{ Bool bool }
+
+func (*Column_String_) isColumn_Value() {}
+func (*Column_Int32) isColumn_Value()   {}
+func (*Column_Int64) isColumn_Value()   {}
+func (*Column_Uint32) isColumn_Value()  {}
+func (*Column_Uint64) isColumn_Value()  {}
+func (*Column_Bytes) isColumn_Value()   {}
+func (*Column_Bool) isColumn_Value()    {}
+
+func (c *Column) GetString_() string {
+	if v, ok := c.Value.(*Column_String_); ok {
+		return v.String_
+	}
+	return ""
+}
+
+func (c *Column) GetInt64() int64 {
+	if v, ok := c.Value.(*Column_Int64); ok {
+		return v.Int64
+	}
+	return 0
+}
+
+func (c *Column) GetBytes() []byte {
+	if v, ok := c.Value.(*Column_Bytes); ok {
+		return v.Bytes
+	}
+	return nil
+}
+
+func (c *Column) GetBool() bool {
+	if v, ok := c.Value.(*Column_Bool); ok {
+		return v.Bool
+	}
+	return false
+}
+
+// keyValueStore is the part of the ledger API the tables are built on
+type keyValueStore interface {
+	GetState(key string) ([]byte, error)
+	PutState(key string, value []byte) error
+	DelState(key string) error
+	GetStateByPartialCompositeKey(objectType string, keys []string) (shim.StateQueryIteratorInterface, error)
+}
+
+// tables implements the table operations of Stub for one transaction. Fabric does not let a transaction
+// read its own writes, so the tables it creates are remembered until it ends.
+type tables struct {
+	store   keyValueStore
+	created map[string]*Table
+}
+
+func newTables(store keyValueStore) *tables {
+	return &tables{store: store, created: map[string]*Table{}}
+}
+
+func (t *tables) CreateTable(name string, columnDefinitions []*ColumnDefinition) error {
+	if _, err := t.GetTable(name); err == nil {
+		return fmt.Errorf("CreateTable operation failed. Table %s already exists.", name)
+	}
+	keys := 0
+	for _, def := range columnDefinitions {
+		if def.Key {
+			keys++
+		}
+	}
+	if keys == 0 {
+		return errors.New("Invalid table. Table must have at least one key column.")
+	}
+
+	table := &Table{Name: name, ColumnDefinitions: columnDefinitions}
+	tableAsBytes, err := json.Marshal(table)
+	if err != nil {
+		return errors.New("Error marshalling table " + name)
+	}
+	key, err := shim.CreateCompositeKey(tableDefinitionType, []string{name})
+	if err != nil {
+		return err
+	}
+	if err := t.store.PutState(key, tableAsBytes); err != nil {
+		return err
+	}
+	t.created[name] = table
+	return nil
+}
+
+// GetTable returns the definition of a table, or an error if it does not exist
+func (t *tables) GetTable(name string) (*Table, error) {
+	if table, found := t.created[name]; found {
+		return table, nil
+	}
+	key, err := shim.CreateCompositeKey(tableDefinitionType, []string{name})
+	if err != nil {
+		return nil, err
+	}
+	tableAsBytes, err := t.store.GetState(key)
+	if err != nil {
+		return nil, err
+	}
+	if len(tableAsBytes) == 0 {
+		return nil, fmt.Errorf("Table %s not found.", name)
+	}
+	var table Table
+	if err := json.Unmarshal(tableAsBytes, &table); err != nil {
+		return nil, errors.New("Error unmarshalling table " + name)
+	}
+	return &table, nil
+}
+
+// DeleteTable deletes a table and its rows
+func (t *tables) DeleteTable(name string) error {
+	rows, err := t.store.GetStateByPartialCompositeKey(name, []string{})
+	if err != nil {
+		return err
+	}
+	var keys []string
+	for rows.HasNext() {
+		kv, err := rows.Next()
+		if err != nil {
+			rows.Close()
+			return err
+		}
+		keys = append(keys, kv.Key)
+	}
+	rows.Close()
+
+	for _, key := range keys {
+		if err := t.store.DelState(key); err != nil {
+			return err
+		}
+	}
+	delete(t.created, name)
+	key, err := shim.CreateCompositeKey(tableDefinitionType, []string{name})
+	if err != nil {
+		return err
+	}
+	return t.store.DelState(key)
+}
+
+// InsertRow adds a row, returning false if a row with the same key exists
+func (t *tables) InsertRow(tableName string, row Row) (bool, error) {
+	return t.writeRow(tableName, row, false)
+}
+
+// ReplaceRow overwrites a row, returning false if no row has its key
+func (t *tables) ReplaceRow(tableName string, row Row) (bool, error) {
+	return t.writeRow(tableName, row, true)
+}
+
+func (t *tables) writeRow(tableName string, row Row, replace bool) (bool, error) {
+	table, err := t.GetTable(tableName)
+	if err != nil {
+		return false, err
+	}
+	defs := table.ColumnDefinitions
+	if len(row.Columns) != len(defs) {
+		return false, fmt.Errorf("Invalid row for table %s: expecting %d columns, got %d", tableName, len(defs), len(row.Columns))
+	}
+
+	var keyValues []string
+	values := make([]interface{}, len(defs))
+	for i, def := range defs {
+		if row.Columns[i] == nil {
+			return false, fmt.Errorf("Invalid row for table %s: column %s is nil", tableName, def.Name)
+		}
+		value, err := columnValue(def, row.Columns[i])
+		if err != nil {
+			return false, fmt.Errorf("Invalid row for table %s: %s", tableName, err.Error())
+		}
+		values[i] = value
+		if def.Key {
+			keyValues = append(keyValues, keyString(row.Columns[i]))
+		}
+	}
+
+	key, err := shim.CreateCompositeKey(tableName, keyValues)
+	if err != nil {
+		return false, err
+	}
+	existing, err := t.store.GetState(key)
+	if err != nil {
+		return false, err
+	}
+	if (len(existing) != 0) != replace {
+		return false, nil
+	}
+
+	rowAsBytes, err := json.Marshal(values)
+	if err != nil {
+		return false, errors.New("Error marshalling row of table " + tableName)
+	}
+	return true, t.store.PutState(key, rowAsBytes)
+}
+
+// GetRow returns the row with the given key, or an empty row if there is none
+func (t *tables) GetRow(tableName string, key []Column) (Row, error) {
+	table, err := t.GetTable(tableName)
+	if err != nil {
+		return Row{}, err
+	}
+	compositeKey, err := shim.CreateCompositeKey(tableName, keyStrings(key))
+	if err != nil {
+		return Row{}, err
+	}
+	rowAsBytes, err := t.store.GetState(compositeKey)
+	if err != nil || len(rowAsBytes) == 0 {
+		return Row{}, err
+	}
+	return decodeRow(table, rowAsBytes)
+}
+
+// GetRows returns the rows whose key starts with the given key columns, in key order
+func (t *tables) GetRows(tableName string, key []Column) (<-chan Row, error) {
+	table, err := t.GetTable(tableName)
+	if err != nil {
+		return nil, err
+	}
+	iterator, err := t.store.GetStateByPartialCompositeKey(tableName, keyStrings(key))
+	if err != nil {
+		return nil, err
+	}
+	defer iterator.Close()
+
+	// The rows are read before returning, callers may write while they range over the channel
+	var rows []Row
+	for iterator.HasNext() {
+		kv, err := iterator.Next()
+		if err != nil {
+			return nil, err
+		}
+		row, err := decodeRow(table, kv.Value)
+		if err != nil {
+			return nil, err
+		}
+		rows = append(rows, row)
+	}
+
+	c := make(chan Row, len(rows))
+	for _, row := range rows {
+		c <- row
+	}
+	close(c)
+	return c, nil
+}
+
+// ScanRows calls f with the rows whose key starts with the given key columns, in key order, until f
+// returns false. Unlike GetRows it reads one row at a time, so a scan that stops early reads no further.
+func (t *tables) ScanRows(tableName string, key []Column, f func(Row) (bool, error)) error {
+	table, err := t.GetTable(tableName)
+	if err != nil {
+		return err
+	}
+	iterator, err := t.store.GetStateByPartialCompositeKey(tableName, keyStrings(key))
+	if err != nil {
+		return err
+	}
+	defer iterator.Close()
+
+	for iterator.HasNext() {
+		kv, err := iterator.Next()
+		if err != nil {
+			return err
+		}
+		row, err := decodeRow(table, kv.Value)
+		if err != nil {
+			return err
+		}
+		more, err := f(row)
+		if err != nil || !more {
+			return err
+		}
+	}
+	return nil
+}
+
+func (t *tables) DeleteRow(tableName string, key []Column) error {
+	if _, err := t.GetTable(tableName); err != nil {
+		return err
+	}
+	compositeKey, err := shim.CreateCompositeKey(tableName, keyStrings(key))
+	if err != nil {
+		return err
+	}
+	return t.store.DelState(compositeKey)
+}
+
+// columnValue returns the value of a column as stored, checking it has the type of its definition
+func columnValue(def *ColumnDefinition, c *Column) (interface{}, error) {
+	var value interface{}
+	var ok bool
+	switch def.Type {
+	case ColumnDefinition_STRING:
+		var v *Column_String_
+		v, ok = c.Value.(*Column_String_)
+		if ok {
+			value = v.String_
+		}
+	case ColumnDefinition_INT32:
+		var v *Column_Int32
+		v, ok = c.Value.(*Column_Int32)
+		if ok {
+			value = v.Int32
+		}
+	case ColumnDefinition_INT64:
+		var v *Column_Int64
+		v, ok = c.Value.(*Column_Int64)
+		if ok {
+			value = v.Int64
+		}
+	case ColumnDefinition_UINT32:
+		var v *Column_Uint32
+		v, ok = c.Value.(*Column_Uint32)
+		if ok {
+			value = v.Uint32
+		}
+	case ColumnDefinition_UINT64:
+		var v *Column_Uint64
+		v, ok = c.Value.(*Column_Uint64)
+		if ok {
+			value = v.Uint64
+		}
+	case ColumnDefinition_BYTES:
+		var v *Column_Bytes
+		v, ok = c.Value.(*Column_Bytes)
+		if ok {
+			value = v.Bytes
+		}
+	case ColumnDefinition_BOOL:
+		var v *Column_Bool
+		v, ok = c.Value.(*Column_Bool)
+		if ok {
+			value = v.Bool
+		}
+	}
+	if !ok {
+		return nil, fmt.Errorf("column %s does not hold a value of its type", def.Name)
+	}
+	return value, nil
+}
+
+// decodeRow converts a stored JSON array back to a row of the table
+func decodeRow(table *Table, rowAsBytes []byte) (Row, error) {
+	var values []json.RawMessage
+	if err := json.Unmarshal(rowAsBytes, &values); err != nil || len(values) != len(table.ColumnDefinitions) {
+		return Row{}, errors.New("Error unmarshalling row of table " + table.Name)
+	}
+
+	row := Row{Columns: make([]*Column, len(values))}
+	for i, def := range table.ColumnDefinitions {
+		var err error
+		switch def.Type {
+		case ColumnDefinition_STRING:
+			v := &Column_String_{}
+			err = json.Unmarshal(values[i], &v.String_)
+			row.Columns[i] = &Column{Value: v}
+		case ColumnDefinition_INT32:
+			v := &Column_Int32{}
+			err = json.Unmarshal(values[i], &v.Int32)
+			row.Columns[i] = &Column{Value: v}
+		case ColumnDefinition_INT64:
+			v := &Column_Int64{}
+			err = json.Unmarshal(values[i], &v.Int64)
+			row.Columns[i] = &Column{Value: v}
+		case ColumnDefinition_UINT32:
+			v := &Column_Uint32{}
+			err = json.Unmarshal(values[i], &v.Uint32)
+			row.Columns[i] = &Column{Value: v}
+		case ColumnDefinition_UINT64:
+			v := &Column_Uint64{}
+			err = json.Unmarshal(values[i], &v.Uint64)
+			row.Columns[i] = &Column{Value: v}
+		case ColumnDefinition_BYTES:
+			v := &Column_Bytes{}
+			err = json.Unmarshal(values[i], &v.Bytes)
+			row.Columns[i] = &Column{Value: v}
+		case ColumnDefinition_BOOL:
+			v := &Column_Bool{}
+			err = json.Unmarshal(values[i], &v.Bool)
+			row.Columns[i] = &Column{Value: v}
+		}
+		if err != nil {
+			return Row{}, errors.New("Error unmarshalling column " + def.Name + " of table " + table.Name)
+		}
+	}
+	return row, nil
+}
+
+// keyString returns a key column value as a composite key attribute
+func keyString(c *Column) string {
+	switch v := c.Value.(type) {
+	case *Column_String_:
+		return v.String_
+	case *Column_Int32:
+		return strconv.FormatInt(int64(v.Int32), 10)
+	case *Column_Int64:
+		return strconv.FormatInt(v.Int64, 10)
+	case *Column_Uint32:
+		return strconv.FormatUint(uint64(v.Uint32), 10)
+	case *Column_Uint64:
+		return strconv.FormatUint(v.Uint64, 10)
+	case *Column_Bytes:
+		return string(v.Bytes)
+	case *Column_Bool:
+		return strconv.FormatBool(v.Bool)
+	}
+	return ""
+}
+
+func keyStrings(key []Column) []string {
+	values := make([]string, len(key))
+	for i := range key {
+		values[i] = keyString(&key[i])
+	}
+	return values
+}